@@ -0,0 +1,276 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// default options
+const (
+	DefaultSocket        = "/tmp/prom_multi_proc.sock"
+	DefaultBatchSize     = 100
+	DefaultFlushInterval = time.Second
+	DefaultQueueSize     = 10000
+	DefaultTimeout       = time.Second
+	DefaultMaxBackoff    = 30 * time.Second
+)
+
+// ErrClosed is returned when flushing a closed client.
+var ErrClosed = errors.New("client is closed")
+
+type Options struct {
+	// path of the unix socket prom_multi_proc listens on
+	Socket string
+	// number of metrics sent at once
+	BatchSize int
+	// interval at which metrics are sent when a batch is not full
+	FlushInterval time.Duration
+	// number of metrics waiting to be sent before more are dropped
+	QueueSize int
+	// timeout connecting and writing to the socket
+	Timeout time.Duration
+	// maximum time to wait before retrying after an error
+	MaxBackoff time.Duration
+	// tenant of metrics sent without one, the default tenant if empty
+	Tenant string
+}
+
+// Client sends metrics to a prom_multi_proc socket in batches, from a
+// goroutine, so that callers never block on the socket. A batch is sent
+// when it is full or every flush interval. If sending fails, the batch is
+// retried after a backoff, and metrics which cannot be queued meanwhile
+// are dropped.
+type Client struct {
+	opts    Options
+	queue   chan Metric
+	flushCh chan chan error
+	done    chan struct{}
+	wg      sync.WaitGroup
+
+	closeOnce sync.Once
+	closeErr  error
+
+	sent    uint64
+	dropped uint64
+	errors  uint64
+}
+
+// New returns a client started with opts, zero options are set to their
+// defaults.
+func New(opts Options) *Client {
+	if opts.Socket == "" {
+		opts.Socket = DefaultSocket
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+
+	c := &Client{
+		opts:    opts,
+		queue:   make(chan Metric, opts.QueueSize),
+		flushCh: make(chan chan error),
+		done:    make(chan struct{}),
+	}
+	c.wg.Add(1)
+	go c.run()
+
+	return c
+}
+
+// Send queues m to be sent. It returns false if m was dropped because the
+// queue is full or the client is closed.
+func (c *Client) Send(m Metric) bool {
+	if m.Tenant == "" {
+		m.Tenant = c.opts.Tenant
+	}
+
+	select {
+	case <-c.done:
+		atomic.AddUint64(&c.dropped, 1)
+		return false
+	default:
+	}
+
+	select {
+	case c.queue <- m:
+		return true
+	default:
+		atomic.AddUint64(&c.dropped, 1)
+		return false
+	}
+}
+
+func (c *Client) Inc(name string, labelValues ...string) bool {
+	return c.Send(Metric{Name: name, LabelValues: labelValues, Method: "inc"})
+}
+
+func (c *Client) Add(name string, value float64, labelValues ...string) bool {
+	return c.Send(Metric{Name: name, LabelValues: labelValues, Method: "add", Value: value})
+}
+
+func (c *Client) Set(name string, value float64, labelValues ...string) bool {
+	return c.Send(Metric{Name: name, LabelValues: labelValues, Method: "set", Value: value})
+}
+
+func (c *Client) Observe(name string, value float64, labelValues ...string) bool {
+	return c.Send(Metric{Name: name, LabelValues: labelValues, Method: "observe", Value: value})
+}
+
+// Flush sends the metrics queued so far, and returns the error sending
+// them, if any.
+func (c *Client) Flush() error {
+	errCh := make(chan error, 1)
+	select {
+	case c.flushCh <- errCh:
+		return <-errCh
+	case <-c.done:
+		return ErrClosed
+	}
+}
+
+// Close sends the queued metrics and stops the client.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.wg.Wait()
+	})
+	return c.closeErr
+}
+
+// Sent returns the number of metrics sent.
+func (c *Client) Sent() uint64 {
+	return atomic.LoadUint64(&c.sent)
+}
+
+// Dropped returns the number of metrics dropped.
+func (c *Client) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// Errors returns the number of failed attempts to send a batch.
+func (c *Client) Errors() uint64 {
+	return atomic.LoadUint64(&c.errors)
+}
+
+func (c *Client) run() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.opts.FlushInterval)
+	defer ticker.Stop()
+
+	var (
+		batch   []Metric
+		backoff time.Duration
+		retry   <-chan time.Time
+	)
+
+	// send sends the batch, or schedules a retry if it fails
+	send := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := c.write(batch)
+		if err != nil {
+			atomic.AddUint64(&c.errors, 1)
+			if backoff == 0 {
+				backoff = c.opts.FlushInterval
+			} else {
+				backoff *= 2
+			}
+			if backoff > c.opts.MaxBackoff {
+				backoff = c.opts.MaxBackoff
+			}
+			retry = time.After(backoff)
+			return err
+		}
+		atomic.AddUint64(&c.sent, uint64(len(batch)))
+		batch = nil
+		backoff = 0
+		retry = nil
+		return nil
+	}
+
+	for {
+		// stop taking from the queue while the batch is full or waiting
+		// to be retried, so the queue fills and further metrics drop
+		queue := c.queue
+		if len(batch) >= c.opts.BatchSize || retry != nil {
+			queue = nil
+		}
+
+		select {
+		case m := <-queue:
+			batch = append(batch, m)
+			if len(batch) >= c.opts.BatchSize {
+				send()
+			}
+		case <-ticker.C:
+			if retry == nil {
+				send()
+			}
+		case <-retry:
+			retry = nil
+			send()
+		case errCh := <-c.flushCh:
+			errCh <- c.drain(&batch, send)
+		case <-c.done:
+			c.closeErr = c.drain(&batch, send)
+			atomic.AddUint64(&c.dropped, uint64(len(batch)+len(c.queue)))
+			return
+		}
+	}
+}
+
+// drain sends the batch and everything queued, stopping at the first
+// error.
+func (c *Client) drain(batch *[]Metric, send func() error) error {
+	for {
+		if err := send(); err != nil {
+			return err
+		}
+		for len(*batch) < c.opts.BatchSize {
+			select {
+			case m := <-c.queue:
+				*batch = append(*batch, m)
+				continue
+			default:
+			}
+			break
+		}
+		if len(*batch) == 0 {
+			return nil
+		}
+	}
+}
+
+// write sends metrics on a new connection, since prom_multi_proc reads a
+// single batch per connection.
+func (c *Client) write(metrics []Metric) error {
+	conn, err := net.DialTimeout("unix", c.opts.Socket, c.opts.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(c.opts.Timeout)); err != nil {
+		return err
+	}
+
+	return json.NewEncoder(conn).Encode(metrics)
+}
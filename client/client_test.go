@@ -0,0 +1,149 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T, socket string) <-chan []Metric {
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	batchCh := make(chan []Metric, 100)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var buf bytes.Buffer
+			io.Copy(&buf, c)
+			c.Close()
+
+			var metrics []Metric
+			if err := json.Unmarshal(buf.Bytes(), &metrics); err != nil {
+				t.Error(err)
+			}
+			batchCh <- metrics
+		}
+	}()
+
+	return batchCh
+}
+
+func tempSocket(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "test.sock")
+}
+
+func receive(t *testing.T, batchCh <-chan []Metric) []Metric {
+	select {
+	case batch := <-batchCh:
+		return batch
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a batch")
+		return nil
+	}
+}
+
+func TestClientBatchSize(t *testing.T) {
+	socket := tempSocket(t)
+	batchCh := listen(t, socket)
+
+	c := New(Options{Socket: socket, BatchSize: 2, FlushInterval: time.Hour})
+	defer c.Close()
+
+	c.Inc("one_total", "a")
+	c.Add("one_total", 2, "b")
+	c.Set("two", 3)
+
+	batch := receive(t, batchCh)
+	if len(batch) != 2 {
+		t.Fatalf("Expected a batch of 2, but got %+v", batch)
+	}
+	if batch[0].Method != "inc" || batch[0].LabelValues[0] != "a" || batch[1].Method != "add" || batch[1].Value != 2 {
+		t.Errorf("Unexpected batch %+v", batch)
+	}
+
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	batch = receive(t, batchCh)
+	if len(batch) != 1 || batch[0].Name != "two" || batch[0].Value != 3 {
+		t.Errorf("Unexpected batch %+v", batch)
+	}
+
+	if c.Sent() != 3 || c.Dropped() != 0 {
+		t.Errorf("Expected 3 sent and 0 dropped, but got %d and %d", c.Sent(), c.Dropped())
+	}
+}
+
+func TestClientFlushInterval(t *testing.T) {
+	socket := tempSocket(t)
+	batchCh := listen(t, socket)
+
+	c := New(Options{Socket: socket, FlushInterval: 10 * time.Millisecond})
+	defer c.Close()
+
+	c.Observe("three", 0.5)
+	batch := receive(t, batchCh)
+	if len(batch) != 1 || batch[0].Method != "observe" {
+		t.Errorf("Unexpected batch %+v", batch)
+	}
+}
+
+func TestClientBackpressure(t *testing.T) {
+	socket := tempSocket(t)
+
+	// batches are only sent when full, and retried within the max backoff
+	c := New(Options{Socket: socket, BatchSize: 2, QueueSize: 2, FlushInterval: time.Hour, MaxBackoff: 20 * time.Millisecond})
+
+	sent := 0
+	for i := 0; i < 10; i++ {
+		if c.Inc("one_total") {
+			sent++
+		}
+	}
+	if sent == 10 || c.Dropped() == 0 {
+		t.Errorf("Expected metrics to be dropped, but %d were queued", sent)
+	}
+	if err := c.Flush(); err == nil {
+		t.Error("Expected an error flushing without a socket")
+	}
+	if c.Errors() == 0 {
+		t.Error("Expected errors to be counted")
+	}
+
+	// the batch is retried once the socket is listening
+	batchCh := listen(t, socket)
+	if batch := receive(t, batchCh); len(batch) == 0 {
+		t.Error("Expected the batch to be retried, but it was empty")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Sent() != uint64(sent) || c.Sent()+c.Dropped() != 10 {
+		t.Errorf("Expected %d sent and %d dropped, but got %d and %d", sent, 10-sent, c.Sent(), c.Dropped())
+	}
+	if c.Inc("one_total") {
+		t.Error("Expected send after close to be dropped")
+	}
+	if err := c.Flush(); err != ErrClosed {
+		t.Errorf("Flush after close => %v, want %v", err, ErrClosed)
+	}
+}
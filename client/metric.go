@@ -0,0 +1,91 @@
+// Package client sends metrics to a prom_multi_proc socket.
+//
+// The socket protocol is a json array of Metric per connection. Metrics
+// are defined in prom_multi_proc by a MetricSpec.
+package client
+
+// MetricSpec defines a metric which can be sent.
+type MetricSpec struct {
+	Type       string                `json:"type"`
+	Name       string                `json:"name"`
+	Help       string                `json:"help"`
+	Labels     []string              `json:"labels"`
+	Buckets    []float64             `json:"buckets"`
+	BucketSpec *BucketSpec           `json:"bucket_spec"`
+	Objectives map[string]float64    `json:"objectives"`
+	MaxAge     string                `json:"max_age"`
+	AgeBuckets uint32                `json:"age_buckets"`
+	BufCap     uint32                `json:"buf_cap"`
+	Relabel    []RelabelRule         `json:"relabel"`
+	LabelRules map[string]*LabelRule `json:"label_rules"`
+	Scale      float64               `json:"scale"`
+	Min        *float64              `json:"min"`
+	Max        *float64              `json:"max"`
+
+	// file the spec was loaded from
+	File string `json:"-"`
+}
+
+// BucketSpec generates histogram buckets. Exactly one of its fields
+// should be set.
+type BucketSpec struct {
+	Preset      string                 `json:"preset"`
+	Exponential *ExponentialBucketSpec `json:"exponential"`
+	Linear      *LinearBucketSpec      `json:"linear"`
+}
+
+type ExponentialBucketSpec struct {
+	Start  float64 `json:"start"`
+	Factor float64 `json:"factor"`
+	Count  int     `json:"count"`
+}
+
+type LinearBucketSpec struct {
+	Start float64 `json:"start"`
+	Width float64 `json:"width"`
+	Count int     `json:"count"`
+}
+
+// RelabelRule rewrites the value of a label before a metric is handled.
+// Action is one of replace (the default), lowercase, hashmod, drop, keep
+// or other.
+type RelabelRule struct {
+	Label       string `json:"label"`
+	Action      string `json:"action"`
+	Regex       string `json:"regex"`
+	Replacement string `json:"replacement"`
+	Modulus     uint64 `json:"modulus"`
+}
+
+// LabelRule validates the values of a label. Invalid values are handled
+// by OnInvalid, which is one of reject (the default), truncate or replace.
+type LabelRule struct {
+	AllowedValues []string `json:"allowed_values"`
+	Pattern       string   `json:"pattern"`
+	MaxLength     int      `json:"max_length"`
+	OnInvalid     string   `json:"on_invalid"`
+	Placeholder   string   `json:"placeholder"`
+}
+
+// Metric is a single sample of a metric, applied with method.
+type Metric struct {
+	Name        string   `json:"name"`
+	LabelValues []string `json:"label_values"`
+	Method      string   `json:"method"`
+	Value       float64  `json:"value"`
+
+	// used by the observe_many histogram and summary method
+	Values []float64 `json:"values,omitempty"`
+
+	// used by the observe_buckets histogram method
+	Buckets      []float64 `json:"buckets,omitempty"`
+	BucketCounts []uint64  `json:"bucket_counts,omitempty"`
+	Sum          float64   `json:"sum,omitempty"`
+
+	// number of times value is observed by the observe method, or the
+	// total count for the observe_buckets method
+	Count uint64 `json:"count,omitempty"`
+
+	// tenant the metric belongs to, the default tenant if empty
+	Tenant string `json:"tenant,omitempty"`
+}
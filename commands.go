@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/atongen/prom_multi_proc/server"
+)
+
+// lintCommand implements the lint subcommand, it returns the exit code.
+func lintCommand(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	fs.SetOutput(out)
+	metrics := fs.String("metrics", "", "Path to json, yaml or toml file, directory or glob of files which contain metric definitions")
+	warnings := fs.Bool("warnings", true, "Report warnings as well as errors")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *metrics == "" {
+		fmt.Fprintln(out, "-metrics is required")
+		return 2
+	}
+
+	problems, err := server.LintSpecs(*metrics)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+
+	status := 0
+	for _, p := range problems {
+		if p.Severity == server.SeverityError {
+			status = 1
+		} else if !*warnings {
+			continue
+		}
+		fmt.Fprintln(out, p)
+	}
+
+	return status
+}
+
+// diffCommand implements the diff subcommand, it returns the exit code.
+// Problems loading the specs are written to errOut, so that out is only
+// the changes.
+func diffCommand(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	jsonOut := fs.Bool("json", false, "Print changes as json")
+	fs.Usage = func() {
+		fmt.Fprintln(errOut, "Usage: prom_multi_proc diff [-json] old new")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	var sets [2]*server.SpecSet
+	for i, path := range fs.Args() {
+		set, err := server.LoadSpecs(path)
+		if err != nil {
+			fmt.Fprintln(errOut, err)
+			return 2
+		}
+		for _, serr := range set.Errors {
+			fmt.Fprintln(errOut, serr)
+		}
+		sets[i] = set
+	}
+
+	changes := server.DiffSpecs(sets[0].Specs, sets[1].Specs)
+
+	status := 0
+	for _, c := range changes {
+		if c.Breaking {
+			status = 1
+		}
+	}
+
+	if *jsonOut {
+		if changes == nil {
+			changes = []server.SpecChange{}
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		enc.Encode(changes)
+		return status
+	}
+
+	for _, c := range changes {
+		breaking := ""
+		if c.Breaking {
+			breaking = "BREAKING"
+		}
+		line := fmt.Sprintf("%-8s  %-17s  %s", breaking, c.Change, c.Name)
+		if c.Detail != "" {
+			line += " (" + c.Detail + ")"
+		}
+		fmt.Fprintln(out, line)
+	}
+
+	return status
+}
+
+// labelPairs is a flag of name=value label pairs, given comma separated or
+// by repeating the flag.
+type labelPairs [][2]string
+
+func (l *labelPairs) String() string {
+	var pairs []string
+	for _, p := range *l {
+		pairs = append(pairs, p[0]+"="+p[1])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l *labelPairs) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return fmt.Errorf("Label '%s' must be name=value", pair)
+		}
+		*l = append(*l, [2]string{pair[:i], pair[i+1:]})
+	}
+	return nil
+}
+
+// labelValues returns the label values of l in the order of the spec
+// labels, or in the order given if spec is nil.
+func (l labelPairs) labelValues(spec *server.MetricSpec) ([]string, error) {
+	var values []string
+	if spec == nil {
+		for _, p := range l {
+			values = append(values, p[1])
+		}
+		return values, nil
+	}
+
+	given := make(map[string]string)
+	for _, p := range l {
+		given[p[0]] = p[1]
+	}
+	for _, label := range spec.Labels {
+		value, ok := given[label]
+		if !ok {
+			return nil, server.NewMetricError(server.ReasonLabelMismatch, "Label %s of metric %s is missing", label, spec.Name)
+		}
+		values = append(values, value)
+		delete(given, label)
+	}
+	for _, p := range l {
+		if _, ok := given[p[0]]; ok {
+			return nil, server.NewMetricError(server.ReasonLabelMismatch, "Label %s is not a label of metric %s", p[0], spec.Name)
+		}
+	}
+	return values, nil
+}
+
+// validateSend returns an error if metric would be rejected by a
+// registry of specs.
+func validateSend(specs map[string]*server.MetricSpec, metric *server.Metric) error {
+	spec, ok := specs[metric.Name]
+	if !ok {
+		return server.NewMetricError(server.ReasonUnknownMetric, "Metric %s does not exist", metric.Name)
+	}
+	return server.ValidateMetric(spec, metric)
+}
+
+// sendMetrics writes metrics to the socket as a single batch.
+func sendMetrics(socket string, metrics []server.Metric) error {
+	c, err := net.Dial("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := json.NewEncoder(c).Encode(metrics); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readMetrics decodes newline delimited json metrics from r, calling fn
+// with each batch of at most size metrics.
+func readMetrics(r io.Reader, size int, fn func([]server.Metric) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var (
+		batch []server.Metric
+		line  int
+	)
+	for scanner.Scan() {
+		line++
+		b := strings.TrimSpace(scanner.Text())
+		if b == "" {
+			continue
+		}
+
+		var metric server.Metric
+		if err := json.Unmarshal([]byte(b), &metric); err != nil {
+			return &server.PositionError{Line: line, Err: err}
+		}
+		batch = append(batch, metric)
+
+		if len(batch) >= size {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+	return nil
+}
+
+// sendCommand implements the send subcommand, it returns the exit code.
+// Flags may be given before or after the metric name. Without a metric
+// name, newline delimited json metrics are read from in.
+func sendCommand(args []string, in io.Reader, out io.Writer) int {
+	var labels labelPairs
+
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	fs.SetOutput(out)
+	socket := fs.String("socket", "/tmp/prom_multi_proc.sock", "Path to unix socket to send metrics to")
+	metrics := fs.String("metrics", "", "Path to json, yaml or toml file, directory or glob of files to validate metrics against before sending")
+	method := fs.String("method", "inc", "Method of the metric")
+	value := fs.Float64("value", 0, "Value of the metric")
+	batch := fs.Int("batch", 1000, "Number of metrics read from stdin to send at once")
+	fs.Var(&labels, "labels", "Comma separated name=value labels of the metric, may be repeated")
+	fs.Usage = func() {
+		fmt.Fprintln(out, "Usage: prom_multi_proc send [flags] [name]")
+		fmt.Fprintln(out, "Reads newline delimited json metrics from stdin if name is not given.")
+		fs.PrintDefaults()
+	}
+
+	var names []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return 2
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		names = append(names, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(names) > 1 || *batch < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	var specs map[string]*server.MetricSpec
+	if *metrics != "" {
+		set, err := server.LoadSpecs(*metrics)
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return 2
+		}
+		for _, serr := range set.Errors {
+			fmt.Fprintln(out, serr)
+		}
+		specs = make(map[string]*server.MetricSpec)
+		for _, spec := range set.Specs {
+			specs[spec.Name] = spec
+		}
+	}
+
+	send := func(batch []server.Metric) error {
+		if specs != nil {
+			for i := range batch {
+				if err := validateSend(specs, &batch[i]); err != nil {
+					return err
+				}
+			}
+		}
+		return sendMetrics(*socket, batch)
+	}
+
+	var err error
+	if len(names) == 0 {
+		err = readMetrics(in, *batch, send)
+	} else {
+		metric := server.Metric{Name: names[0], Method: *method, Value: *value}
+		var spec *server.MetricSpec
+		if specs != nil {
+			spec = specs[metric.Name]
+		}
+		metric.LabelValues, err = labels.labelValues(spec)
+		if err == nil {
+			err = send([]server.Metric{metric})
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/atongen/prom_multi_proc/server"
+)
+
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLintCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	good := writeTestFile(t, dir, "good.json", `[{"type": "counter", "name": "requests_total", "help": "Requests"}]`)
+	warn := writeTestFile(t, dir, "warn.json", `[{"type": "counter", "name": "requests", "help": "Requests"}]`)
+	bad := writeTestFile(t, dir, "bad.json", `[{"type": "counter", "name": "bad-name", "help": "Bad"}]`)
+
+	for _, tt := range []struct {
+		args   []string
+		status int
+	}{
+		{[]string{"-metrics", good}, 0},
+		{[]string{"-metrics", warn}, 0},
+		{[]string{"-metrics", bad}, 1},
+		{[]string{}, 2},
+		{[]string{"-metrics", dir + "/nope.json"}, 2},
+	} {
+		var out bytes.Buffer
+		if status := lintCommand(tt.args, &out); status != tt.status {
+			t.Errorf("lint %v => %d, want %d: %s", tt.args, status, tt.status, out.String())
+		}
+	}
+}
+
+func TestDiffCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := writeTestFile(t, dir, "a.json", `[{"type": "counter", "name": "a_total", "help": "A"}]`)
+	b := writeTestFile(t, dir, "b.yaml", "- type: counter\n  name: a_total\n  help: Changed\n")
+	c := writeTestFile(t, dir, "c.json", `[]`)
+
+	for _, tt := range []struct {
+		args   []string
+		status int
+	}{
+		{[]string{a, b}, 0},
+		{[]string{a, c}, 1},
+		{[]string{a}, 2},
+		{[]string{a, dir + "/nope.json"}, 2},
+	} {
+		var out, errOut bytes.Buffer
+		if status := diffCommand(tt.args, &out, &errOut); status != tt.status {
+			t.Errorf("diff %v => %d, want %d: %s%s", tt.args, status, tt.status, out.String(), errOut.String())
+		}
+	}
+
+	var out, errOut bytes.Buffer
+	diffCommand([]string{"-json", a, c}, &out, &errOut)
+
+	var changes []server.SpecChange
+	if err := json.Unmarshal(out.Bytes(), &changes); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Change != server.ChangeRemoved || !changes[0].Breaking {
+		t.Errorf("Expected a_total to be removed, but got %+v", changes)
+	}
+}
+
+// listenTestSocket returns the path of a socket and a channel of the
+// metric batches written to it.
+func listenTestSocket(t *testing.T, dir string) (string, <-chan []server.Metric) {
+	socket := filepath.Join(dir, "test.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	batchCh := make(chan []server.Metric, 10)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var buf bytes.Buffer
+			io.Copy(&buf, c)
+			c.Close()
+
+			var metrics []server.Metric
+			if err := json.Unmarshal(buf.Bytes(), &metrics); err != nil {
+				t.Error(err)
+			}
+			batchCh <- metrics
+		}
+	}()
+
+	return socket, batchCh
+}
+
+func TestSendCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket, batchCh := listenTestSocket(t, dir)
+	metrics := writeTestFile(t, dir, "metrics.json", `[
+		{"type": "counter", "name": "jobs_total", "help": "Jobs", "labels": ["job", "status"]}
+	]`)
+
+	var out bytes.Buffer
+	args := []string{"-socket", socket, "-metrics", metrics, "jobs_total", "--labels", "status=ok", "--labels", "job=backup", "--method", "add", "--value", "2"}
+	if status := sendCommand(args, nil, &out); status != 0 {
+		t.Fatalf("send %v => %d, want 0: %s", args, status, out.String())
+	}
+
+	batch := <-batchCh
+	expected := server.Metric{Name: "jobs_total", LabelValues: []string{"backup", "ok"}, Method: "add", Value: 2}
+	if len(batch) != 1 || batch[0].Name != expected.Name || strings.Join(batch[0].LabelValues, ",") != strings.Join(expected.LabelValues, ",") ||
+		batch[0].Method != expected.Method || batch[0].Value != expected.Value {
+		t.Errorf("Expected %+v, but got %+v", expected, batch)
+	}
+
+	for _, args := range [][]string{
+		{"-socket", socket, "-metrics", metrics, "nope_total"},
+		{"-socket", socket, "-metrics", metrics, "jobs_total", "-labels", "job=backup"},
+		{"-socket", socket, "-metrics", metrics, "jobs_total", "-labels", "job=backup,status=ok,host=a"},
+		{"-socket", socket, "-metrics", metrics, "jobs_total", "-labels", "job=backup,status=ok", "-method", "set"},
+		{"-socket", socket, "-metrics", metrics, "jobs_total", "-labels", "job=backup,status=ok", "-method", "add", "-value", "-1"},
+	} {
+		if status := sendCommand(args, nil, &out); status != 1 {
+			t.Errorf("send %v => %d, want 1", args, status)
+		}
+	}
+
+	if status := sendCommand([]string{"-socket", socket, "one", "two"}, nil, &out); status != 2 {
+		t.Errorf("send with two names => %d, want 2", status)
+	}
+
+	in := strings.NewReader(`{"name": "jobs_total", "label_values": ["a", "ok"], "method": "inc"}
+
+{"name": "jobs_total", "label_values": ["b", "ok"], "method": "inc"}
+{"name": "jobs_total", "label_values": ["c", "ok"], "method": "inc"}
+`)
+	args = []string{"-socket", socket, "-metrics", metrics, "-batch", "2"}
+	if status := sendCommand(args, in, &out); status != 0 {
+		t.Fatalf("send %v => %d, want 0: %s", args, status, out.String())
+	}
+	for _, n := range []int{2, 1} {
+		if batch := <-batchCh; len(batch) != n {
+			t.Errorf("Expected a batch of %d metrics, but got %+v", n, batch)
+		}
+	}
+
+	in = strings.NewReader("{\"name\": \"jobs_total\"}\nnope\n")
+	if status := sendCommand([]string{"-socket", socket}, in, &out); status != 1 {
+		t.Errorf("send invalid json => %d, want 1", status)
+	}
+	if !strings.Contains(out.String(), "line 2") {
+		t.Errorf("Expected error on line 2, but got %s", out.String())
+	}
+}
+
+func TestLabelPairs(t *testing.T) {
+	var l labelPairs
+	for _, v := range []string{"a=1,b=2", "c=x=y", "d="} {
+		if err := l.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if l.String() != "a=1,b=2,c=x=y,d=" {
+		t.Errorf("labelPairs => %s, want a=1,b=2,c=x=y,d=", l.String())
+	}
+
+	for _, v := range []string{"a", "=1", "a=1,"} {
+		if err := l.Set(v); err == nil {
+			t.Errorf("labelPairs.Set(%s) => nil, want error", v)
+		}
+	}
+}
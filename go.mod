@@ -2,7 +2,13 @@ module github.com/atongen/prom_multi_proc
 
 go 1.17
 
-require github.com/prometheus/client_golang v0.8.1-0.20170108232857-74f9ce27f652
+require (
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/pelletier/go-toml v1.9.5
+	github.com/prometheus/client_golang v0.8.1-0.20170108232857-74f9ce27f652
+	github.com/prometheus/client_model v0.0.0-20190812154241-14fe0d1b01d4
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/Songmu/retry v0.1.0 // indirect
@@ -16,7 +22,6 @@ require (
 	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	github.com/prometheus/client_model v0.0.0-20190812154241-14fe0d1b01d4 // indirect
 	github.com/prometheus/common v0.0.0-20170108231212-dd2f054febf4 // indirect
 	github.com/prometheus/procfs v0.0.0-20161206222141-fcdb11ccb438 // indirect
 	github.com/tcnksm/ghr v0.14.0 // indirect
@@ -25,7 +30,7 @@ require (
 	golang.org/x/net v0.0.0-20220325170049-de3da57026de // indirect
 	golang.org/x/oauth2 v0.0.0-20220309155454-6242fa91716a // indirect
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
-	golang.org/x/sys v0.0.0-20220330033206-e17cdc41300f // indirect
+	golang.org/x/sys v0.4.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/protobuf v1.28.0 // indirect
 )
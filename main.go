@@ -1,18 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"net"
-	"net/http"
 	"os"
 	"os/signal"
 	"path"
-	"runtime"
 	"syscall"
 
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/atongen/prom_multi_proc/server"
 )
 
 // build flags
@@ -26,15 +23,22 @@ var (
 // cli flags
 var (
 	socketFlag  = flag.String("socket", "/tmp/prom_multi_proc.sock", "Path to unix socket to listen on for incoming metrics")
-	metricsFlag = flag.String("metrics", "", "Path to json file which contains metric definitions")
+	metricsFlag = flag.String("metrics", "", "Path to json, yaml or toml file, directory or glob of files which contain metric definitions")
 	addrFlag    = flag.String("addr", "0.0.0.0:9299", "Address to listen on for exposing prometheus metrics")
 	pathFlag    = flag.String("path", "/metrics", "Path to use for exposing prometheus metrics")
 	logFlag     = flag.String("log", "", "Path to log file, will write to STDOUT if empty")
+	strictFlag  = flag.Bool("strict", false, "Reject metrics sent with a method that is invalid for their type")
+	configFlag  = flag.String("config", "", "Path to json, yaml or toml config file of processing stages, relabel rules, auto registration, listeners and tenants")
+	adminFlag   = flag.String("admin-addr", "", "Address to listen on for the admin api, it is not served if empty")
+	watchFlag   = flag.Bool("watch", false, "Reload metric definitions automatically when the metrics file changes")
 	versionFlag = flag.Bool("v", false, "Print version information and exit")
 )
 
-func init() {
-	prometheus.MustRegister(metricsTotal)
+// subcommands, run as prom_multi_proc <command> [flags]
+var commands = map[string]func(args []string) int{
+	"lint": func(args []string) int { return lintCommand(args, os.Stdout) },
+	"diff": func(args []string) int { return diffCommand(args, os.Stdout, os.Stderr) },
+	"send": func(args []string) int { return sendCommand(args, os.Stdin, os.Stderr) },
 }
 
 func versionStr() string {
@@ -42,6 +46,12 @@ func versionStr() string {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
 	flag.Parse()
 
 	if *versionFlag {
@@ -49,39 +59,72 @@ func main() {
 		os.Exit(0)
 	}
 
+	server.SetBuildInfo(Version, BuildHash, GoVersion)
+
 	// setup logger, this may be reloaded later with HUP signal
-	err := SetLogger(*logFlag)
+	err := server.SetLogger(*logFlag)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	server.Logger().Println(versionStr())
 
-	// setup metrics and done channels
-	metricCh := make(chan Metric)
-	dataCh := make(chan []byte)
-	doneCh := make(chan bool)
-
-	// begin listening on socket
-	ln, err := net.Listen("unix", *socketFlag)
-	if err != nil {
-		logger.Fatal(err)
+	var registry server.Registry
+	if *strictFlag {
+		registry = server.NewStrictRegistry()
+	} else {
+		registry = server.NewRegistry()
 	}
-	defer ln.Close()
 
-	err = os.Chmod(*socketFlag, 0777)
-	if err != nil {
-		logger.Fatal(err)
+	var (
+		stages    server.Chain
+		listeners []server.Listener
+		tenants   []server.Tenant
+	)
+	if *configFlag != "" {
+		config, err := server.LoadConfig(*configFlag)
+		if err == nil {
+			stages, err = config.Chain()
+		}
+		registries := []server.Registry{registry}
+		if err == nil {
+			listeners, tenants = config.Listeners, config.Tenants
+			for i := range tenants {
+				tenants[i].Registry = server.NewTenantRegistry(*strictFlag)
+				registries = append(registries, tenants[i].Registry)
+			}
+		}
+		// relabel rules and auto registration apply to every tenant
+		for _, r := range registries {
+			if err == nil {
+				err = r.SetRelabelRules(config.Relabel)
+			}
+			if err == nil {
+				err = r.SetAutoRegister(config.AutoRegister)
+			}
+		}
+		if err != nil {
+			server.Logger().Println(err)
+			os.Exit(1)
+		}
 	}
 
+	srv := server.NewServer(server.Options{
+		Socket:    *socketFlag,
+		Metrics:   *metricsFlag,
+		Addr:      *addrFlag,
+		Path:      *pathFlag,
+		AdminAddr: *adminFlag,
+		Registry:  registry,
+		Watch:     *watchFlag,
+		Stages:    stages,
+		Listeners: listeners,
+		Tenants:   tenants,
+	})
+
 	// listen for signals which make us quit
-	sigc := make(chan os.Signal, 1)
-	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGKILL)
-	go func() {
-		<-sigc
-		logger.Println("Goodbye!")
-		ln.Close()
-		os.Exit(0)
-	}()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
 
 	// listen for USR1 signal which makes us reload our metrics definitions
 	sigu := make(chan os.Signal, 1)
@@ -89,99 +132,38 @@ func main() {
 	go func() {
 		for {
 			<-sigu
-			logger.Println("USR1 Signal received")
-			// stop the data processor
-			doneCh <- true
+			server.Logger().Println("USR1 Signal received")
+			srv.Reload()
 		}
 	}()
 
-	registry := NewRegistry()
-
-	go func() {
-		defer func() {
-			// recover a panic here to make sure socket gets cleaned up
-			if r := recover(); r != nil {
-				logger.Printf("Recovered panic: %s", r)
-				ln.Close()
-				os.Exit(1)
-			}
-		}()
-		// this for loop must always either continue, or
-		// exit the process, in other words, never break;
-		// otherwise data processing will stop and USR1
-		// signals will not reload the metrics definition json
-		for {
-			logger.Println(versionStr())
-			logger.Println("Loading metric configuration")
-
-			// note beginning names of metrics
-			names := registry.Names()
-
-			// reload metrics definitions file
-			specs, err := LoadSpecs(*metricsFlag)
-			if err != nil {
-				logger.Printf("Error loading configuration: %s", err)
-			} else {
-				// only register/unregister if there is no error processing
-				// the metrics definition json
-				newNames := []string{}
-				for _, spec := range specs {
-					newNames = append(newNames, spec.Name)
-					if err := registry.Register(spec); err != nil {
-						logger.Println(err)
-					} else {
-						logger.Printf("Registered %s", spec.Name)
-					}
-				}
-
-				// get names of metrics no longer present and unregister them
-				unreg := sliceSubStr(names, newNames)
-				for _, name := range unreg {
-					if err := registry.Unregister(name); err != nil {
-						logger.Println(err)
-					} else {
-						logger.Printf("Unregistered %s", name)
-					}
-				}
-			}
-
-			// begin processing incoming metrics
-			DataProcessor(registry, metricCh, doneCh)
-		}
-
-		// Ensure this process ends if we ever return from the for loop.
-		logger.Println("Data processing has ended")
-		ln.Close()
-		os.Exit(1)
-	}()
-
 	// listen for HUP signal which makes us reopen our log file descriptors
+	sighErr := make(chan error, 1)
 	sigh := make(chan os.Signal, 1)
 	signal.Notify(sigh, syscall.SIGHUP)
 	go func() {
 		for {
 			<-sigh
-			logger.Println("Re-opening logs...")
-			err := SetLogger(*logFlag)
-			if err != nil {
-				fmt.Println(err)
-				ln.Close()
-				os.Exit(1)
+			server.Logger().Println("Re-opening logs...")
+			if err := server.SetLogger(*logFlag); err != nil {
+				sighErr <- err
+				stop()
+				return
 			}
 		}
 	}()
 
-	workers := runtime.NumCPU()
-	for i := 0; i < workers; i++ {
-		go DataParser(dataCh, metricCh)
+	if err := srv.Run(ctx); err != nil {
+		server.Logger().Println(err)
+		os.Exit(1)
 	}
 
-	go DataReader(ln, dataCh)
+	select {
+	case err := <-sighErr:
+		fmt.Println(err)
+		os.Exit(1)
+	default:
+	}
 
-	// setup prometheus http handlers and begin listening
-	promHandler := promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
-		ErrorLog: logger,
-	})
-	http.Handle(*pathFlag, promHandler)
-	http.ListenAndServe(*addrFlag, nil)
+	server.Logger().Println("Goodbye!")
 }
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+type reloadResponse struct {
+	Outcomes []ReloadOutcome           `json:"outcomes"`
+	Error    string                    `json:"error,omitempty"`
+	Tenants  map[string]reloadResponse `json:"tenants,omitempty"`
+}
+
+// failed returns true if the specs of the default tenant or any tenant
+// failed to load.
+func (r reloadResponse) failed() bool {
+	if r.Error != "" {
+		return true
+	}
+	for _, t := range r.Tenants {
+		if t.failed() {
+			return true
+		}
+	}
+	return false
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// AdminHandler returns the admin api of the server, served on AdminAddr.
+// The api of a tenant is chosen by the tenant query parameter.
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, errorResponse{"method must be POST"})
+			return
+		}
+
+		s.logger().Println("Reload requested via admin api")
+		result := s.reloadAll()
+		if result.failed() {
+			writeJSON(w, http.StatusInternalServerError, result)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, result)
+	})
+
+	mux.HandleFunc("/api/specs", func(w http.ResponseWriter, r *http.Request) {
+		registry, ok := s.adminRegistry(w, r)
+		if !ok {
+			return
+		}
+
+		specs := registry.Specs()
+		if specs == nil {
+			specs = []*MetricSpec{}
+		}
+		writeJSON(w, http.StatusOK, specs)
+	})
+
+	mux.HandleFunc("/api/series", func(w http.ResponseWriter, r *http.Request) {
+		registry, ok := s.adminRegistry(w, r)
+		if !ok {
+			return
+		}
+
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"name is required"})
+			return
+		}
+
+		series, err := registry.Series(name)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if ErrorReason(err) == ReasonUnknownMetric {
+				status = http.StatusNotFound
+			}
+			writeJSON(w, status, errorResponse{err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, series)
+	})
+
+	return mux
+}
+
+// adminRegistry returns the registry of the tenant query parameter of r,
+// the default registry if it is empty. It responds with 404 if there is
+// no such tenant.
+func (s *Server) adminRegistry(w http.ResponseWriter, r *http.Request) (Registry, bool) {
+	tenant := r.URL.Query().Get("tenant")
+	if tenant == "" {
+		return s.registry, true
+	}
+
+	registry, ok := s.tenants[tenant]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{fmt.Sprintf("Tenant %s does not exist", tenant)})
+	}
+	return registry, ok
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestAdminHandler(t *testing.T) {
+	SetTestLogger()
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := writeTestFile(t, dir, "metrics.json", `[
+		{"type": "counter", "name": "test_admin_counter", "help": "Counter"},
+		{"type": "gauge", "name": "test_admin_gauge_vec", "help": "Gauge", "labels": ["one", "two"]}
+	]`)
+
+	registry := NewRegistry()
+	server := httptest.NewServer(NewServer(Options{Registry: registry, Metrics: file}).AdminHandler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/-/reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET reload status %d, but got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+
+	var reload reloadResponse
+	resp, err = http.Post(server.URL+"/-/reload", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reload); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(reload.Outcomes) != 2 {
+		t.Fatalf("Expected reload to register 2 metrics, but got %d %+v", resp.StatusCode, reload)
+	}
+
+	var specs []*MetricSpec
+	resp, err = http.Get(server.URL + "/api/specs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&specs); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(specs) != 2 || specs[0].Name != "test_admin_counter" {
+		t.Errorf("Expected 2 specs, but got %+v", specs)
+	}
+
+	for _, lvs := range [][]string{{"b", "c"}, {"a", "b"}} {
+		m := Metric{Name: "test_admin_gauge_vec", Method: "set", LabelValues: lvs, Value: 1}
+		if err := registry.Handle(&m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var series []map[string]string
+	resp, err = http.Get(server.URL + "/api/series?name=test_admin_gauge_vec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&series); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(series) != 2 || series[0]["one"] != "a" || series[1]["two"] != "c" {
+		t.Errorf("Expected 2 sorted series, but got %v", series)
+	}
+
+	resp, err = http.Get(server.URL + "/api/series?name=nope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected unknown series status %d, but got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestAdminHandlerTenants(t *testing.T) {
+	SetTestLogger()
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := writeTestFile(t, dir, "metrics.json", `[]`)
+	billingFile := writeTestFile(t, dir, "billing.json", `[
+		{"type": "gauge", "name": "test_admin_tenant_gauge", "help": "Gauge", "labels": ["one"]}
+	]`)
+
+	s := NewServer(Options{
+		Registry: NewTenantRegistry(false),
+		Metrics:  file,
+		Tenants:  []Tenant{{Name: "billing", Metrics: billingFile}},
+	})
+	server := httptest.NewServer(s.AdminHandler())
+	defer server.Close()
+
+	var reload reloadResponse
+	resp, err := http.Post(server.URL+"/-/reload", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reload); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if outcomes := reload.Tenants["billing"].Outcomes; resp.StatusCode != http.StatusOK || len(outcomes) != 1 || outcomes[0].Outcome != OutcomeRegistered {
+		t.Fatalf("Expected reload to register the metric of the tenant, but got %d %+v", resp.StatusCode, reload)
+	}
+
+	var specs []*MetricSpec
+	resp, err = http.Get(server.URL + "/api/specs?tenant=billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&specs); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(specs) != 1 || specs[0].Name != "test_admin_tenant_gauge" {
+		t.Errorf("Expected the spec of the tenant, but got %+v", specs)
+	}
+
+	m := Metric{Name: "test_admin_tenant_gauge", Method: "set", LabelValues: []string{"a"}, Value: 1}
+	if err := s.TenantRegistry("billing").Handle(&m); err != nil {
+		t.Fatal(err)
+	}
+	var series []map[string]string
+	resp, err = http.Get(server.URL + "/api/series?tenant=billing&name=test_admin_tenant_gauge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&series); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(series) != 1 || series[0]["one"] != "a" {
+		t.Errorf("Expected the series of the tenant, but got %v", series)
+	}
+
+	resp, err = http.Get(server.URL + "/api/specs?tenant=nope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected unknown tenant status %d, but got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	writeTestFile(t, dir, "billing.json", `[{"type": "gauge",`)
+	resp, err = http.Post(server.URL+"/-/reload", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected failed reload of a tenant status %d, but got %d", http.StatusInternalServerError, resp.StatusCode)
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// File of the specs of automatically registered metrics.
+const AutoRegisteredFile = "(auto)"
+
+const defaultAutoRegisterHelp = "Automatically registered metric"
+
+// AutoRegister configures the automatic registration of unknown metrics.
+type AutoRegister struct {
+	// rules tried in order for each unknown metric name
+	Rules []AutoRegisterRule `json:"rules"`
+
+	// maximum number of metrics which are automatically registered
+	Max int `json:"max"`
+}
+
+// AutoRegisterRule registers unknown metrics with names matching the
+// anchored regex Match. The spec of a metric is Template with its name
+// replaced, or if Template has no type it is inferred from the method.
+type AutoRegisterRule struct {
+	Match    string      `json:"match"`
+	Template *MetricSpec `json:"template"`
+}
+
+type autoRegisterer struct {
+	rules []autoRegisterRule
+	max   int
+}
+
+type autoRegisterRule struct {
+	re       *regexp.Regexp
+	template MetricSpec
+}
+
+// compileAutoRegister validates and compiles the rules of config.
+func compileAutoRegister(config *AutoRegister) (*autoRegisterer, error) {
+	if config == nil || len(config.Rules) == 0 {
+		return nil, nil
+	}
+	if config.Max <= 0 {
+		return nil, fmt.Errorf("Auto register max must be positive")
+	}
+
+	a := &autoRegisterer{max: config.Max}
+	for i, rule := range config.Rules {
+		if rule.Match == "" {
+			return nil, fmt.Errorf("Auto register rule %d must have a match", i+1)
+		}
+		re, err := regexp.Compile("^(?:" + rule.Match + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Auto register rule %d has invalid match '%s': %s", i+1, rule.Match, err)
+		}
+
+		var template MetricSpec
+		if rule.Template != nil {
+			template = *rule.Template
+		}
+		if template.Help == "" {
+			template.Help = defaultAutoRegisterHelp
+		}
+
+		// check the template as a spec of a type with no options of its own
+		check := template
+		check.Name = "auto_register_template"
+		if check.Type == "" {
+			check.Type = "gauge"
+		}
+		if errs := specErrors(&check); len(errs) > 0 {
+			return nil, fmt.Errorf("Auto register rule %d template: %s", i+1, errs[0])
+		}
+
+		a.rules = append(a.rules, autoRegisterRule{re, template})
+	}
+
+	return a, nil
+}
+
+// spec returns the spec to register for metric, or nil if no rule
+// matches its name.
+func (a *autoRegisterer) spec(metric *Metric) *MetricSpec {
+	if a == nil {
+		return nil
+	}
+
+	for _, rule := range a.rules {
+		if !rule.re.MatchString(metric.Name) {
+			continue
+		}
+		spec := rule.template
+		spec.Name = metric.Name
+		spec.File = AutoRegisteredFile
+		if spec.Type == "" {
+			spec.Type = inferType(metric.Method)
+		}
+		return &spec
+	}
+
+	return nil
+}
+
+// inferType returns the type of metric which is usually sent method.
+func inferType(method string) string {
+	switch method {
+	case "inc", "add":
+		return "counter"
+	case "observe", "observe_many", "observe_buckets":
+		return "histogram"
+	default:
+		return "gauge"
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCompileAutoRegisterFail(t *testing.T) {
+	for _, config := range []*AutoRegister{
+		{Rules: []AutoRegisterRule{{Match: "test_.*"}}},
+		{Max: 1, Rules: []AutoRegisterRule{{}}},
+		{Max: 1, Rules: []AutoRegisterRule{{Match: "("}}},
+		{Max: 1, Rules: []AutoRegisterRule{{Match: "test_.*", Template: &MetricSpec{Type: "nope"}}}},
+		{Max: 1, Rules: []AutoRegisterRule{{Match: "test_.*", Template: &MetricSpec{Labels: []string{"__bad"}}}}},
+	} {
+		if _, err := compileAutoRegister(config); err == nil {
+			t.Errorf("compileAutoRegister(%+v) => nil, want error", config)
+		}
+	}
+}
+
+func TestRegistryAutoRegister(t *testing.T) {
+	SetTestLogger()
+	registry := NewRegistry()
+
+	err := registry.SetAutoRegister(&AutoRegister{
+		Max: 2,
+		Rules: []AutoRegisterRule{
+			{Match: "test_auto_.*_seconds", Template: &MetricSpec{Type: "summary", Labels: []string{"route"}}},
+			{Match: "test_auto_.*"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, name := range registry.Names() {
+			registry.Unregister(name)
+		}
+	}()
+
+	for _, tt := range []struct {
+		metric Metric
+		reason string
+	}{
+		{Metric{Name: "test_other_total", Method: "inc"}, ReasonUnknownMetric},
+		{Metric{Name: "test_auto_jobs_total", Method: "add", Value: 2}, ""},
+		{Metric{Name: "test_auto_jobs_total", Method: "inc"}, ""},
+		{Metric{Name: "test_auto_job_seconds", Method: "observe", Value: 1}, ReasonUnknownMetric},
+		{Metric{Name: "test_auto_bad-name", Method: "inc"}, ReasonUnknownMetric},
+		{Metric{Name: "test_auto_job_seconds", Method: "observe", Value: 1, LabelValues: []string{"/"}}, ""},
+		{Metric{Name: "test_auto_queue", Method: "set", Value: 1}, ReasonUnknownMetric},
+	} {
+		m := tt.metric
+		err := registry.Handle(&m)
+		if err != nil || tt.reason != "" {
+			if ErrorReason(err) != tt.reason {
+				t.Errorf("Handle(%+v) => %v, want reason %q", tt.metric, err, tt.reason)
+			}
+		}
+	}
+
+	types := make(map[string]string)
+	for _, spec := range registry.Specs() {
+		if spec.File != AutoRegisteredFile || spec.Help == "" {
+			t.Errorf("Expected %s to be auto registered with help, but got %+v", spec.Name, spec)
+		}
+		types[spec.Name] = spec.Type
+	}
+	if len(types) != 2 || types["test_auto_jobs_total"] != "counter" || types["test_auto_job_seconds"] != "summary" {
+		t.Errorf("Expected a counter and a summary to be auto registered, but got %v", types)
+	}
+
+	// unregistering makes room for another metric
+	if err := registry.Unregister("test_auto_jobs_total"); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Handle(&Metric{Name: "test_auto_queue", Method: "set", Value: 1}); err != nil {
+		t.Errorf("Expected test_auto_queue to be auto registered, but got %v", err)
+	}
+}
+
+func TestReloadSpecsAutoRegistered(t *testing.T) {
+	SetTestLogger()
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	registry := NewRegistry()
+	if err := registry.SetAutoRegister(&AutoRegister{Max: 10, Rules: []AutoRegisterRule{{Match: "test_reload_auto_.*"}}}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, name := range registry.Names() {
+			registry.Unregister(name)
+		}
+	}()
+
+	for _, name := range []string{"test_reload_auto_one", "test_reload_auto_two"} {
+		if err := registry.Handle(&Metric{Name: name, Method: "inc"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file := writeTestFile(t, dir, "metrics.json", `[
+		{"type": "gauge", "name": "test_reload_auto_two", "help": "Two"},
+		{"type": "gauge", "name": "test_reload_three", "help": "Three"}
+	]`)
+	outcomes, err := ReloadSpecs(registry, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"test_reload_auto_one": OutcomeUnchanged,
+		"test_reload_auto_two": OutcomeRejected,
+		"test_reload_three":    OutcomeRegistered,
+	}
+	if len(outcomes) != len(expected) {
+		t.Fatalf("Expected %d outcomes, but got %+v", len(expected), outcomes)
+	}
+	for _, o := range outcomes {
+		if o.Outcome != expected[o.Name] {
+			t.Errorf("Expected %s to be %s, but was %s", o.Name, expected[o.Name], o.Outcome)
+		}
+	}
+
+	if _, err := ReloadSpecs(registry, file); err != nil {
+		t.Fatal(err)
+	}
+	if names := registry.Names(); len(names) != 3 {
+		t.Errorf("Expected auto registered metrics to be kept, but got %v", names)
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var bucketPresets = map[string][]float64{
+	"default": defaultBuckets,
+	"http_latency": {
+		0.001,
+		0.0025,
+		0.005,
+		0.01,
+		0.025,
+		0.05,
+		0.1,
+		0.25,
+		0.5,
+		1.0,
+		2.5,
+		5.0,
+		10.0,
+		30.0,
+	},
+	// 64B to 16MiB in powers of four
+	"bytes": prometheus.ExponentialBuckets(64, 4, 10),
+}
+
+// bucketSpecBuckets returns the buckets generated by s.
+func bucketSpecBuckets(s *BucketSpec) ([]float64, error) {
+	n := 0
+	if s.Preset != "" {
+		n++
+	}
+	if s.Exponential != nil {
+		n++
+	}
+	if s.Linear != nil {
+		n++
+	}
+	if n != 1 {
+		return nil, errors.New("bucket_spec must have exactly one of preset, exponential or linear")
+	}
+
+	switch {
+	case s.Exponential != nil:
+		e := s.Exponential
+		if e.Count < 1 {
+			return nil, fmt.Errorf("exponential bucket count must be positive, got %d", e.Count)
+		}
+		if e.Start <= 0 {
+			return nil, fmt.Errorf("exponential bucket start must be positive, got %g", e.Start)
+		}
+		if e.Factor <= 1 {
+			return nil, fmt.Errorf("exponential bucket factor must be greater than 1, got %g", e.Factor)
+		}
+		return prometheus.ExponentialBuckets(e.Start, e.Factor, e.Count), nil
+	case s.Linear != nil:
+		l := s.Linear
+		if l.Count < 1 {
+			return nil, fmt.Errorf("linear bucket count must be positive, got %d", l.Count)
+		}
+		if l.Width <= 0 {
+			return nil, fmt.Errorf("linear bucket width must be positive, got %g", l.Width)
+		}
+		return prometheus.LinearBuckets(l.Start, l.Width, l.Count), nil
+	default:
+		buckets, ok := bucketPresets[s.Preset]
+		if !ok {
+			return nil, fmt.Errorf("Unknown bucket preset %s, must be one of: %s", s.Preset, strings.Join(bucketPresetNames(), ", "))
+		}
+		return buckets, nil
+	}
+}
+
+// specBuckets returns the histogram buckets for spec, either listed
+// directly, generated from its bucket_spec, or the defaults.
+func specBuckets(spec *MetricSpec) ([]float64, error) {
+	var buckets []float64
+
+	switch {
+	case len(spec.Buckets) > 0 && spec.BucketSpec != nil:
+		return nil, fmt.Errorf("Metric %s cannot have both buckets and bucket_spec", spec.Name)
+	case len(spec.Buckets) > 0:
+		buckets = spec.Buckets
+	case spec.BucketSpec != nil:
+		var err error
+		buckets, err = bucketSpecBuckets(spec.BucketSpec)
+		if err != nil {
+			return nil, fmt.Errorf("Metric %s: %s", spec.Name, err)
+		}
+	default:
+		buckets = defaultBuckets
+	}
+
+	if err := validateBuckets(buckets); err != nil {
+		return nil, fmt.Errorf("Metric %s: %s", spec.Name, err)
+	}
+
+	return buckets, nil
+}
+
+func validateBuckets(buckets []float64) error {
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			return fmt.Errorf("buckets must be strictly increasing: %g >= %g", buckets[i-1], buckets[i])
+		}
+	}
+
+	return nil
+}
+
+func bucketPresetNames() []string {
+	var result []string
+
+	for name := range bucketPresets {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+
+	return result
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSpecBuckets(t *testing.T) {
+	for _, tt := range []struct {
+		json    string
+		buckets []float64
+	}{
+		{`{}`, defaultBuckets},
+		{`{"buckets": [0.1, 0.5, 1]}`, []float64{0.1, 0.5, 1}},
+		{`{"bucket_spec": {"preset": "http_latency"}}`, bucketPresets["http_latency"]},
+		{`{"bucket_spec": {"exponential": {"start": 1, "factor": 2, "count": 4}}}`, []float64{1, 2, 4, 8}},
+		{`{"bucket_spec": {"linear": {"start": 0, "width": 5, "count": 3}}}`, []float64{0, 5, 10}},
+	} {
+		specs, err := ReadSpecs(strings.NewReader("[" + tt.json + "]"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buckets, err := specBuckets(specs[0])
+		if err != nil {
+			t.Errorf("specBuckets(%s) unexpected error: %s", tt.json, err)
+			continue
+		}
+		if !sliceEqFloat64(buckets, tt.buckets) {
+			t.Errorf("specBuckets(%s) => %v, want %v", tt.json, buckets, tt.buckets)
+		}
+	}
+}
+
+func TestSpecBucketsFail(t *testing.T) {
+	for _, json := range []string{
+		`{"buckets": [0.1, 0.1, 1]}`,
+		`{"buckets": [1, 0.5]}`,
+		`{"buckets": [1], "bucket_spec": {"preset": "bytes"}}`,
+		`{"bucket_spec": {}}`,
+		`{"bucket_spec": {"preset": "nope"}}`,
+		`{"bucket_spec": {"preset": "bytes", "linear": {"start": 0, "width": 1, "count": 2}}}`,
+		`{"bucket_spec": {"exponential": {"start": 0, "factor": 2, "count": 4}}}`,
+		`{"bucket_spec": {"exponential": {"start": 1, "factor": 1, "count": 4}}}`,
+		`{"bucket_spec": {"linear": {"start": 0, "width": 0, "count": 3}}}`,
+		`{"bucket_spec": {"linear": {"start": 0, "width": 1, "count": 0}}}`,
+	} {
+		specs, err := ReadSpecs(strings.NewReader("[" + json + "]"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := specBuckets(specs[0]); err == nil {
+			t.Errorf("specBuckets(%s) expected error, but got none", json)
+		}
+	}
+}
+
+func sliceEqFloat64(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration file, which like the metrics files may be
+// written in json, yaml or toml.
+type Config struct {
+	// processing stages applied in order to each metric before it is
+	// handled, each is an object with the type of the stage and its
+	// options
+	Stages []json.RawMessage `json:"stages"`
+
+	// relabel rules applied to every metric with the label of a rule
+	Relabel []RelabelRule `json:"relabel"`
+
+	// automatic registration of unknown metrics, disabled if not set
+	AutoRegister *AutoRegister `json:"auto_register"`
+
+	// sockets listened on in addition to -socket
+	Listeners []Listener `json:"listeners"`
+
+	// metrics exposed on paths of their own
+	Tenants []Tenant `json:"tenants"`
+}
+
+// LoadConfig reads the config file, choosing its format by extension.
+func LoadConfig(file string) (*Config, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := decodeConfig(filepath.Ext(file), b, &config); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	return &config, nil
+}
+
+// Chain returns the processing stages of the config.
+func (c *Config) Chain() (Chain, error) {
+	var chain Chain
+
+	for i, config := range c.Stages {
+		stage, err := NewStage(config)
+		if err != nil {
+			return nil, fmt.Errorf("stage %d: %s", i+1, err)
+		}
+		chain = append(chain, stage)
+	}
+
+	return chain, nil
+}
+
+// decodeConfig decodes b into v, yaml and toml are converted to json
+// first so that the json field names apply to every format.
+func decodeConfig(ext string, b []byte, v interface{}) error {
+	var value interface{}
+
+	switch ext {
+	case ".yaml", ".yml":
+		var doc yaml.Node
+		if err := yaml.Unmarshal(b, &doc); err != nil {
+			return yamlError(err)
+		}
+		if len(doc.Content) == 0 {
+			return nil
+		}
+		var err error
+		if value, err = yamlValue(doc.Content[0]); err != nil {
+			return err
+		}
+	case ".toml":
+		tree, err := toml.LoadBytes(b)
+		if err != nil {
+			return tomlError(err)
+		}
+		value = tree.ToMap()
+	default:
+		return unmarshalJSON(b, v)
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
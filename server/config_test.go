@@ -0,0 +1,70 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, file := range []string{
+		writeTestFile(t, dir, "config.json", `{"stages": [{"type": "filter", "match": "debug_.*"}, {"type": "scale", "factor": 2}]}`),
+		writeTestFile(t, dir, "config.yaml", `
+stages:
+  - type: filter
+    match: debug_.*
+  - type: scale
+    factor: 2
+`),
+		writeTestFile(t, dir, "config.toml", `
+[[stages]]
+type = "filter"
+match = "debug_.*"
+
+[[stages]]
+type = "scale"
+factor = 2
+`),
+	} {
+		config, err := LoadConfig(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chain, err := config.Chain()
+		if err != nil {
+			t.Fatalf("%s: %s", file, err)
+		}
+		if len(chain) != 2 {
+			t.Fatalf("%s: Expected 2 stages, but got %d", file, len(chain))
+		}
+
+		out, err := chain.Process(Metric{Name: "debug_total", Value: 1})
+		if err != nil || len(out) != 0 {
+			t.Errorf("%s: Expected metric to be dropped, but got %+v %v", file, out, err)
+		}
+		out, err = chain.Process(Metric{Name: "app_total", Value: 1})
+		if err != nil || len(out) != 1 || out[0].Value != 2 {
+			t.Errorf("%s: Expected metric to be scaled, but got %+v %v", file, out, err)
+		}
+	}
+
+	file := writeTestFile(t, dir, "bad.json", `{"stages": [{"type": "nope"}]}`)
+	config, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Chain(); err == nil {
+		t.Error("Expected unknown stage type to fail")
+	}
+
+	file = writeTestFile(t, dir, "bad.yaml", "stages:\n  - type: [\n")
+	if _, err := LoadConfig(file); err == nil {
+		t.Error("Expected invalid yaml to fail")
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// kinds of change between two versions of the metric specs
+const (
+	ChangeAdded        = "added"
+	ChangeRemoved      = "removed"
+	ChangeType         = "type_change"
+	ChangeLabelAdded   = "label_added"
+	ChangeLabelRemoved = "label_removed"
+	ChangeLabelReorder = "label_reordered"
+	ChangeBuckets      = "bucket_change"
+	ChangeObjectives   = "objectives_change"
+	ChangeHelp         = "help_only"
+	ChangeOther        = "other"
+)
+
+// breakingChanges are changes which break queries, dashboards or clients
+// sending label values.
+var breakingChanges = []string{
+	ChangeRemoved,
+	ChangeType,
+	ChangeLabelAdded,
+	ChangeLabelRemoved,
+	ChangeLabelReorder,
+	ChangeBuckets,
+	ChangeObjectives,
+}
+
+type SpecChange struct {
+	Name     string `json:"name"`
+	Change   string `json:"change"`
+	Breaking bool   `json:"breaking"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+func newSpecChange(name, change, detail string) SpecChange {
+	return SpecChange{name, change, sliceContainsStr(breakingChanges, change), detail}
+}
+
+// DiffSpecs returns the changes from the old to the new metric specs,
+// sorted by metric name.
+func DiffSpecs(oldSpecs, newSpecs []*MetricSpec) []SpecChange {
+	var changes []SpecChange
+
+	oldByName := make(map[string]*MetricSpec)
+	for _, spec := range oldSpecs {
+		oldByName[spec.Name] = spec
+	}
+	newByName := make(map[string]*MetricSpec)
+	for _, spec := range newSpecs {
+		newByName[spec.Name] = spec
+	}
+
+	for name, spec := range oldByName {
+		if _, ok := newByName[name]; !ok {
+			changes = append(changes, newSpecChange(name, ChangeRemoved, spec.Type))
+		}
+	}
+
+	for name, newSpec := range newByName {
+		oldSpec, ok := oldByName[name]
+		if !ok {
+			changes = append(changes, newSpecChange(name, ChangeAdded, newSpec.Type))
+			continue
+		}
+		changes = append(changes, diffSpec(oldSpec, newSpec)...)
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Name == changes[j].Name {
+			return changes[i].Change < changes[j].Change
+		}
+		return changes[i].Name < changes[j].Name
+	})
+
+	return changes
+}
+
+func diffSpec(a, b *MetricSpec) []SpecChange {
+	var changes []SpecChange
+	name := a.Name
+
+	if a.Type != b.Type {
+		return []SpecChange{newSpecChange(name, ChangeType, fmt.Sprintf("%s -> %s", a.Type, b.Type))}
+	}
+
+	added := sliceSubStr(b.Labels, a.Labels)
+	removed := sliceSubStr(a.Labels, b.Labels)
+	if len(added) > 0 {
+		changes = append(changes, newSpecChange(name, ChangeLabelAdded, strings.Join(added, ", ")))
+	}
+	if len(removed) > 0 {
+		changes = append(changes, newSpecChange(name, ChangeLabelRemoved, strings.Join(removed, ", ")))
+	}
+	if len(added) == 0 && len(removed) == 0 && !sliceEqStr(a.Labels, b.Labels) {
+		changes = append(changes, newSpecChange(name, ChangeLabelReorder,
+			fmt.Sprintf("%s -> %s", strings.Join(a.Labels, ", "), strings.Join(b.Labels, ", "))))
+	}
+
+	if a.Type == "histogram" {
+		aBuckets, aErr := specBuckets(a)
+		bBuckets, bErr := specBuckets(b)
+		if aErr != nil || bErr != nil || !reflect.DeepEqual(aBuckets, bBuckets) {
+			changes = append(changes, newSpecChange(name, ChangeBuckets, fmt.Sprintf("%v -> %v", aBuckets, bBuckets)))
+		}
+	}
+
+	if a.Type == "summary" {
+		aObjectives, _ := validateObjectives(a.Objectives)
+		bObjectives, _ := validateObjectives(b.Objectives)
+		if !reflect.DeepEqual(aObjectives, bObjectives) {
+			changes = append(changes, newSpecChange(name, ChangeObjectives, fmt.Sprintf("%v -> %v", aObjectives, bObjectives)))
+		}
+	}
+
+	if len(changes) > 0 {
+		return changes
+	}
+
+	// compare everything else, without the fields already compared
+	x, y := *a, *b
+	x.Help, y.Help = "", ""
+	x.Buckets, y.Buckets = nil, nil
+	x.BucketSpec, y.BucketSpec = nil, nil
+	x.Objectives, y.Objectives = nil, nil
+	x.Labels, y.Labels = nil, nil
+	if !specsEqual(&x, &y) {
+		changes = append(changes, newSpecChange(name, ChangeOther, ""))
+	} else if a.Help != b.Help {
+		changes = append(changes, newSpecChange(name, ChangeHelp, ""))
+	}
+
+	return changes
+}
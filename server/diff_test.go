@@ -0,0 +1,57 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffSpecs(t *testing.T) {
+	oldSpecs, err := ReadSpecs(strings.NewReader(`[
+		{"type": "counter", "name": "a_total", "help": "A", "labels": ["x", "y"]},
+		{"type": "counter", "name": "b_total", "help": "B"},
+		{"type": "histogram", "name": "c_seconds", "help": "C", "buckets": [0.1, 1]},
+		{"type": "gauge", "name": "d", "help": "D"},
+		{"type": "gauge", "name": "e", "help": "E", "labels": ["x"]},
+		{"type": "gauge", "name": "g", "help": "G", "labels": ["x", "y"]},
+		{"type": "histogram", "name": "h_seconds", "help": "H", "buckets": [1, 2, 4]},
+		{"type": "summary", "name": "i", "help": "I", "max_age": "1m"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newSpecs, err := ReadSpecs(strings.NewReader(`[
+		{"type": "counter", "name": "a_total", "help": "A", "labels": ["y", "x"]},
+		{"type": "histogram", "name": "c_seconds", "help": "C", "buckets": [0.1, 0.5, 1]},
+		{"type": "counter", "name": "d", "help": "D"},
+		{"type": "gauge", "name": "e", "help": "E2", "labels": ["x"]},
+		{"type": "gauge", "name": "f", "help": "F"},
+		{"type": "gauge", "name": "g", "help": "G", "labels": ["y", "z"]},
+		{"type": "histogram", "name": "h_seconds", "help": "H", "bucket_spec": {"exponential": {"start": 1, "factor": 2, "count": 3}}},
+		{"type": "summary", "name": "i", "help": "I", "max_age": "2m"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []SpecChange{
+		{"a_total", ChangeLabelReorder, true, "x, y -> y, x"},
+		{"b_total", ChangeRemoved, true, "counter"},
+		{"c_seconds", ChangeBuckets, true, "[0.1 1] -> [0.1 0.5 1]"},
+		{"d", ChangeType, true, "gauge -> counter"},
+		{"e", ChangeHelp, false, ""},
+		{"f", ChangeAdded, false, "gauge"},
+		{"g", ChangeLabelAdded, true, "z"},
+		{"g", ChangeLabelRemoved, true, "x"},
+		{"i", ChangeOther, false, ""},
+	}
+
+	changes := DiffSpecs(oldSpecs, newSpecs)
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected changes %+v, but got %+v", expected, changes)
+	}
+	for i := range changes {
+		if changes[i] != expected[i] {
+			t.Errorf("Expected change %+v, but got %+v", expected[i], changes[i])
+		}
+	}
+}
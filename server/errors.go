@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+)
+
+// reasons used for the reason label of pmp_metrics_total
+const (
+	ReasonUnknownMetric   = "unknown_metric"
+	ReasonBadMethod       = "bad_method"
+	ReasonLabelMismatch   = "label_mismatch"
+	ReasonInvalidLabel    = "invalid_label_value"
+	ReasonNegativeCounter = "negative_counter"
+	ReasonInvalidValue    = "invalid_value"
+	ReasonNonFinite       = "non_finite_value"
+	ReasonOutOfRange      = "out_of_range"
+	ReasonNotAllowed      = "not_allowed"
+	ReasonUnknownTenant   = "unknown_tenant"
+	ReasonParseError      = "parse_error"
+	ReasonReadError       = "read_error"
+	ReasonOther           = "other"
+)
+
+// MetricError is returned when an incoming metric cannot be processed,
+// Reason classifies why.
+type MetricError struct {
+	Reason string
+	Err    error
+}
+
+func (e *MetricError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *MetricError) Unwrap() error {
+	return e.Err
+}
+
+func NewMetricError(reason, format string, a ...interface{}) error {
+	return &MetricError{reason, fmt.Errorf(format, a...)}
+}
+
+// Warning is returned when a metric was handled despite a problem, such
+// as a bad method outside of strict mode.
+type Warning struct {
+	Err error
+}
+
+func (w *Warning) Error() string {
+	return w.Err.Error()
+}
+
+func (w *Warning) Unwrap() error {
+	return w.Err
+}
+
+// IsWarning returns true if err is a Warning.
+func IsWarning(err error) bool {
+	var w *Warning
+	return errors.As(err, &w)
+}
+
+// ErrorReason returns the reason of err if it is a MetricError,
+// otherwise ReasonOther.
+func ErrorReason(err error) string {
+	var merr *MetricError
+	if errors.As(err, &merr) {
+		return merr.Reason
+	}
+	return ReasonOther
+}
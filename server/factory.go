@@ -0,0 +1,170 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HandlerFactory builds the handler of a metric spec. The handler's
+// collector is registered by the registry, so the factory should only
+// validate the spec and create it.
+type HandlerFactory func(spec *MetricSpec) (MetricHandler, error)
+
+type handlerType struct {
+	methods []string
+	factory HandlerFactory
+}
+
+var (
+	handlerTypesMu sync.RWMutex
+	handlerTypes   = make(map[string]handlerType)
+)
+
+func init() {
+	MustRegisterHandlerType("counter", []string{"inc", "add"}, newCounterHandler)
+	MustRegisterHandlerType("gauge", []string{"set", "inc", "dec", "add", "sub", "set_to_current_time"}, newGaugeHandler)
+	MustRegisterHandlerType("histogram", []string{"observe", "observe_many", "observe_buckets"}, newHistogramHandler)
+	MustRegisterHandlerType("summary", []string{"observe", "observe_many"}, newSummaryHandler)
+}
+
+// RegisterHandlerType registers factory to build the handlers of metric
+// specs with type name, which accept metrics sent with one of methods.
+// Types should be registered before metric specs are loaded.
+func RegisterHandlerType(name string, methods []string, factory HandlerFactory) error {
+	handlerTypesMu.Lock()
+	defer handlerTypesMu.Unlock()
+
+	if name == "" || factory == nil {
+		return fmt.Errorf("Handler type must have a name and factory")
+	}
+	if _, ok := handlerTypes[name]; ok {
+		return fmt.Errorf("Handler type %s already exists", name)
+	}
+
+	handlerTypes[name] = handlerType{append([]string(nil), methods...), factory}
+	return nil
+}
+
+// MustRegisterHandlerType is like RegisterHandlerType but panics on error.
+func MustRegisterHandlerType(name string, methods []string, factory HandlerFactory) {
+	if err := RegisterHandlerType(name, methods, factory); err != nil {
+		panic(err)
+	}
+}
+
+// HandlerTypes returns the sorted names of the registered handler types.
+func HandlerTypes() []string {
+	handlerTypesMu.RLock()
+	defer handlerTypesMu.RUnlock()
+
+	var result []string
+	for name := range handlerTypes {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+func lookupHandlerType(name string) (handlerType, bool) {
+	handlerTypesMu.RLock()
+	defer handlerTypesMu.RUnlock()
+
+	ht, ok := handlerTypes[name]
+	return ht, ok
+}
+
+func newCounterHandler(spec *MetricSpec) (MetricHandler, error) {
+	opts := prometheus.CounterOpts{
+		Name: spec.Name,
+		Help: spec.Help,
+	}
+	if len(spec.Labels) == 0 {
+		counter := prometheus.NewCounter(opts)
+		return &CounterHandler{spec, counter}, nil
+	}
+
+	if err := validateLabels(spec.Labels); err != nil {
+		return nil, err
+	}
+
+	counterVec := prometheus.NewCounterVec(opts, spec.Labels)
+	return &CounterVecHandler{spec, counterVec}, nil
+}
+
+func newGaugeHandler(spec *MetricSpec) (MetricHandler, error) {
+	opts := prometheus.GaugeOpts{
+		Name: spec.Name,
+		Help: spec.Help,
+	}
+	if len(spec.Labels) == 0 {
+		gauge := prometheus.NewGauge(opts)
+		return &GaugeHandler{spec, gauge}, nil
+	}
+
+	if err := validateLabels(spec.Labels); err != nil {
+		return nil, err
+	}
+
+	gaugeVec := prometheus.NewGaugeVec(opts, spec.Labels)
+	return &GaugeVecHandler{spec, gaugeVec}, nil
+}
+
+func newHistogramHandler(spec *MetricSpec) (MetricHandler, error) {
+	buckets, err := specBuckets(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(spec.Labels) == 0 {
+		desc := prometheus.NewDesc(spec.Name, spec.Help, nil, nil)
+		histogram := newBucketHistogram(desc, buckets)
+		return &HistogramHandler{spec, histogram}, nil
+	}
+
+	if err := validateLabels(spec.Labels, "le"); err != nil {
+		return nil, err
+	}
+
+	desc := prometheus.NewDesc(spec.Name, spec.Help, spec.Labels, nil)
+	histogramVec := newBucketHistogramVec(desc, buckets, spec.Labels)
+	return &HistogramVecHandler{spec, histogramVec}, nil
+}
+
+func newSummaryHandler(spec *MetricSpec) (MetricHandler, error) {
+	var (
+		objectives map[float64]float64
+		err        error
+	)
+	if len(spec.Objectives) > 0 {
+		objectives, err = validateObjectives(spec.Objectives)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		objectives = defaultObjectives
+	}
+	opts := prometheus.SummaryOpts{
+		Name:       spec.Name,
+		Help:       spec.Help,
+		Objectives: objectives,
+		AgeBuckets: spec.AgeBuckets,
+		BufCap:     spec.BufCap,
+	}
+	if opts.MaxAge, err = specMaxAge(spec); err != nil {
+		return nil, err
+	}
+	if len(spec.Labels) == 0 {
+		summary := prometheus.NewSummary(opts)
+		return &SummaryHandler{spec, summary}, nil
+	}
+
+	if err := validateLabels(spec.Labels, "quantile"); err != nil {
+		return nil, err
+	}
+
+	summaryVec := prometheus.NewSummaryVec(opts, spec.Labels)
+	return &SummaryVecHandler{spec, summaryVec}, nil
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// maxHandler is a gauge which keeps the largest value observed.
+type maxHandler struct {
+	spec  *MetricSpec
+	gauge prometheus.Gauge
+	max   float64
+}
+
+func (h *maxHandler) Spec() *MetricSpec {
+	return h.spec
+}
+
+func (h *maxHandler) Handle(m *Metric) error {
+	if m.Value > h.max {
+		h.max = m.Value
+		h.gauge.Set(m.Value)
+	}
+	return nil
+}
+
+func (h *maxHandler) Collector() prometheus.Collector {
+	return h.gauge
+}
+
+func TestRegisterHandlerType(t *testing.T) {
+	SetTestLogger()
+
+	err := RegisterHandlerType("test_max", []string{"observe"}, func(spec *MetricSpec) (MetricHandler, error) {
+		gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: spec.Name, Help: spec.Help})
+		return &maxHandler{spec: spec, gauge: gauge}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		handlerTypesMu.Lock()
+		delete(handlerTypes, "test_max")
+		handlerTypesMu.Unlock()
+	}()
+
+	if err := RegisterHandlerType("test_max", nil, newGaugeHandler); err == nil {
+		t.Error("Expected registering a handler type twice to fail")
+	}
+	if err := RegisterHandlerType("counter", nil, newGaugeHandler); err == nil {
+		t.Error("Expected registering a built-in handler type to fail")
+	}
+
+	types := HandlerTypes()
+	if !sliceEqStr(types, []string{"counter", "gauge", "histogram", "summary", "test_max"}) {
+		t.Errorf("HandlerTypes() => %v", types)
+	}
+
+	registry := NewStrictRegistry()
+	spec := &MetricSpec{Type: "test_max", Name: "test_factory_max", Help: "Max"}
+	if err := registry.Register(spec); err != nil {
+		t.Fatal(err)
+	}
+	defer registry.Unregister(spec.Name)
+
+	for _, v := range []float64{3, 7, 5} {
+		if err := registry.Handle(&Metric{Name: spec.Name, Method: "observe", Value: v}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := registry.Handle(&Metric{Name: spec.Name, Method: "set", Value: 1}); ErrorReason(err) != ReasonBadMethod {
+		t.Errorf("Expected method set to be rejected, but got %v", err)
+	}
+
+	handler := registry.(*ireg).Handlers[spec.Name].(*maxHandler)
+	var m dto.Metric
+	if err := handler.gauge.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.GetGauge().GetValue() != 7 {
+		t.Errorf("Expected max of 7, but got %v", m.GetGauge().GetValue())
+	}
+
+	if errs := specErrors(spec); len(errs) != 0 {
+		t.Errorf("Expected no lint errors for a custom type, but got %v", errs)
+	}
+}
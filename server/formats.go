@@ -0,0 +1,263 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v3"
+)
+
+// specDecoders decode the metric specs of a file by its extension,
+// files with any other extension are decoded as json.
+var specDecoders = map[string]func([]byte) ([]*MetricSpec, error){
+	".json": decodeJSONSpecs,
+	".yaml": decodeYAMLSpecs,
+	".yml":  decodeYAMLSpecs,
+	".toml": decodeTOMLSpecs,
+}
+
+var (
+	yamlLineRe = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+	tomlPosRe  = regexp.MustCompile(`^\((\d+), (\d+)\): (.*)$`)
+)
+
+// PositionError is an error at a line, and column if known, of a metric
+// specs file.
+type PositionError struct {
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *PositionError) Error() string {
+	if e.Column == 0 {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Err)
+}
+
+func decodeJSONSpecs(b []byte) ([]*MetricSpec, error) {
+	var result []*MetricSpec
+	err := unmarshalJSON(b, &result)
+	return result, err
+}
+
+// unmarshalJSON is json.Unmarshal with the position of syntax and type
+// errors.
+func unmarshalJSON(b []byte, v interface{}) error {
+	if err := json.Unmarshal(b, v); err != nil {
+		var offset int64
+		switch e := err.(type) {
+		case *json.SyntaxError:
+			offset = e.Offset
+		case *json.UnmarshalTypeError:
+			offset = e.Offset
+		default:
+			return err
+		}
+		line, column := offsetPosition(b, offset)
+		return &PositionError{line, column, err}
+	}
+
+	return nil
+}
+
+// decodeYAMLSpecs decodes a yaml sequence of metric specs. Each spec is
+// converted to json and decoded like a json spec, so that the json field
+// names apply to yaml as well.
+func decodeYAMLSpecs(b []byte) ([]*MetricSpec, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, yamlError(err)
+	}
+
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.SequenceNode {
+		return nil, &PositionError{root.Line, root.Column, errors.New("expected a list of metric specs")}
+	}
+
+	var result []*MetricSpec
+	for _, item := range root.Content {
+		v, err := yamlValue(item)
+		if err != nil {
+			return result, &PositionError{item.Line, item.Column, err}
+		}
+
+		spec, err := decodeSpecValue(v)
+		if err != nil {
+			n := yamlFieldNode(item, jsonErrorField(err))
+			return result, &PositionError{n.Line, n.Column, err}
+		}
+		result = append(result, spec)
+	}
+
+	return result, nil
+}
+
+// yamlError returns err with its line, if yaml reports one.
+func yamlError(err error) error {
+	if m := yamlLineRe.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return &PositionError{Line: line, Err: errors.New(m[2])}
+	}
+	return err
+}
+
+// yamlValue converts n into values which can be encoded as json, mapping
+// keys are always strings so that objectives like 0.5 are kept as keys.
+func yamlValue(n *yaml.Node) (interface{}, error) {
+	switch n.Kind {
+	case yaml.AliasNode:
+		return yamlValue(n.Alias)
+	case yaml.SequenceNode:
+		result := make([]interface{}, len(n.Content))
+		for i, c := range n.Content {
+			v, err := yamlValue(c)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = v
+		}
+		return result, nil
+	case yaml.MappingNode:
+		result := make(map[string]interface{})
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			v, err := yamlValue(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			result[n.Content[i].Value] = v
+		}
+		return result, nil
+	default:
+		var v interface{}
+		err := n.Decode(&v)
+		return v, err
+	}
+}
+
+// yamlFieldNode returns the node of the dotted json field path within n,
+// or the closest node found.
+func yamlFieldNode(n *yaml.Node, field string) *yaml.Node {
+	if field == "" {
+		return n
+	}
+
+	for _, key := range strings.Split(field, ".") {
+		if n.Kind != yaml.MappingNode {
+			return n
+		}
+		found := false
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == key {
+				n = n.Content[i+1]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return n
+		}
+	}
+
+	return n
+}
+
+// decodeTOMLSpecs decodes metric specs from a toml array of tables named
+// metrics, like:
+//
+//	[[metrics]]
+//	type = "counter"
+//	name = "my_counter"
+func decodeTOMLSpecs(b []byte) ([]*MetricSpec, error) {
+	tree, err := toml.LoadBytes(b)
+	if err != nil {
+		return nil, tomlError(err)
+	}
+
+	var tables []*toml.Tree
+	switch v := tree.Get("metrics").(type) {
+	case nil:
+		return nil, nil
+	case []*toml.Tree:
+		tables = v
+	default:
+		pos := tree.GetPosition("metrics")
+		return nil, &PositionError{pos.Line, pos.Col, errors.New("metrics must be an array of tables")}
+	}
+
+	var result []*MetricSpec
+	for _, table := range tables {
+		spec, err := decodeSpecValue(table.ToMap())
+		if err != nil {
+			pos := table.Position()
+			if field := jsonErrorField(err); field != "" {
+				if fieldPos := table.GetPosition(field); !fieldPos.Invalid() {
+					pos = fieldPos
+				}
+			}
+			return result, &PositionError{pos.Line, pos.Col, err}
+		}
+		result = append(result, spec)
+	}
+
+	return result, nil
+}
+
+// tomlError returns err with its line and column, if toml reports them.
+func tomlError(err error) error {
+	if m := tomlPosRe.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		column, _ := strconv.Atoi(m[2])
+		return &PositionError{line, column, errors.New(m[3])}
+	}
+	return err
+}
+
+func decodeSpecValue(v interface{}) (*MetricSpec, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec MetricSpec
+	if err := json.Unmarshal(b, &spec); err != nil {
+		return nil, err
+	}
+
+	return &spec, nil
+}
+
+func jsonErrorField(err error) string {
+	if e, ok := err.(*json.UnmarshalTypeError); ok {
+		return e.Field
+	}
+	return ""
+}
+
+// offsetPosition converts the offset json reports for an error, which is
+// just past the offending byte, to a line and column.
+func offsetPosition(b []byte, offset int64) (int, int) {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	if offset > 0 {
+		offset--
+	}
+
+	before := b[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+
+	return line, column
+}
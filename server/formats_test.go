@@ -0,0 +1,89 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecodeSpecFormats(t *testing.T) {
+	for _, tt := range []struct {
+		decode func([]byte) ([]*MetricSpec, error)
+		data   string
+	}{
+		{decodeJSONSpecs, `[
+			{"type": "summary", "name": "test_format", "help": "Test", "labels": ["one"],
+			 "objectives": {"0.5": 0.05}, "bucket_spec": {"preset": "bytes"}}
+		]`},
+		{decodeYAMLSpecs, `
+# the comment which json could not hold
+- type: summary
+  name: test_format
+  help: Test
+  labels: [one]
+  objectives:
+    0.5: 0.05
+  bucket_spec:
+    preset: bytes
+`},
+		{decodeTOMLSpecs, `
+# the comment which json could not hold
+[[metrics]]
+type = "summary"
+name = "test_format"
+help = "Test"
+labels = ["one"]
+objectives = { "0.5" = 0.05 }
+bucket_spec = { preset = "bytes" }
+`},
+	} {
+		specs, err := tt.decode([]byte(tt.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(specs) != 1 {
+			t.Fatalf("Expected 1 spec, but got %d", len(specs))
+		}
+
+		spec := specs[0]
+		if spec.Type != "summary" || spec.Name != "test_format" || spec.Help != "Test" {
+			t.Errorf("Unexpected spec %+v", spec)
+		}
+		if !sliceEqStr(spec.Labels, []string{"one"}) {
+			t.Errorf("Expected labels [one], but got %v", spec.Labels)
+		}
+		if spec.Objectives["0.5"] != 0.05 {
+			t.Errorf("Expected objective 0.5 to be 0.05, but got %v", spec.Objectives)
+		}
+		if spec.BucketSpec == nil || spec.BucketSpec.Preset != "bytes" {
+			t.Errorf("Expected bucket preset bytes, but got %+v", spec.BucketSpec)
+		}
+	}
+}
+
+func TestDecodeSpecFormatsPosition(t *testing.T) {
+	for _, tt := range []struct {
+		decode func([]byte) ([]*MetricSpec, error)
+		data   string
+		line   int
+		column int
+	}{
+		{decodeJSONSpecs, "[\n  {\"name\": \"one\"},\n  {\"name\": 1}\n]", 3, 12},
+		{decodeJSONSpecs, "[\n  {\"name\": \"one\"},\n  {\"name\" \"two\"}\n]", 3, 11},
+		{decodeYAMLSpecs, "- name: one\n- name: two\n  labels:\n    nope: 1\n", 4, 5},
+		{decodeYAMLSpecs, "- name: one\n  help: [\n", 2, 0},
+		{decodeYAMLSpecs, "name: one\n", 1, 1},
+		{decodeTOMLSpecs, "[[metrics]]\nname = \"one\"\n\n[[metrics]]\nname = \"two\"\nbuf_cap = \"nope\"\n", 6, 1},
+		{decodeTOMLSpecs, "[[metrics]]\nname = one\n", 2, 8},
+	} {
+		_, err := tt.decode([]byte(tt.data))
+
+		var perr *PositionError
+		if !errors.As(err, &perr) {
+			t.Errorf("Expected position error decoding %q, but got %v", tt.data, err)
+			continue
+		}
+		if perr.Line != tt.line || perr.Column != tt.column {
+			t.Errorf("Expected error decoding %q at %d:%d, but got %s", tt.data, tt.line, tt.column, perr)
+		}
+	}
+}
@@ -1,8 +1,6 @@
-package main
+package server
 
 import (
-	"errors"
-
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -23,14 +21,9 @@ func (h *CounterHandler) Spec() *MetricSpec {
 
 func (h *CounterHandler) Handle(m *Metric) error {
 	switch m.Method {
-	default:
-		logger.Printf("Invalid counter method %s for metric %s\n", m.Method, m.Name)
 	case "inc":
 		h.Counter.Inc()
 	case "add":
-		if m.Value < 0 {
-			return errors.New("counter cannot decrease in value")
-		}
 		h.Counter.Add(m.Value)
 	}
 
@@ -53,18 +46,13 @@ func (h *CounterVecHandler) Spec() *MetricSpec {
 func (h *CounterVecHandler) Handle(m *Metric) error {
 	metric, err := h.CounterVec.GetMetricWithLabelValues(m.LabelValues...)
 	if err != nil {
-		return err
+		return &MetricError{ReasonLabelMismatch, err}
 	}
 
 	switch m.Method {
-	default:
-		logger.Printf("Invalid counter method %s for metric %s\n", m.Method, m.Name)
 	case "inc":
 		metric.Inc()
 	case "add":
-		if m.Value < 0 {
-			return errors.New("counter cannot decrease in value")
-		}
 		metric.Add(m.Value)
 	}
 
@@ -86,8 +74,6 @@ func (h *GaugeHandler) Spec() *MetricSpec {
 
 func (h *GaugeHandler) Handle(m *Metric) error {
 	switch m.Method {
-	default:
-		logger.Printf("Invalid gauge method %s for metric %s\n", m.Method, m.Name)
 	case "set":
 		h.Gauge.Set(m.Value)
 	case "inc":
@@ -121,12 +107,10 @@ func (h *GaugeVecHandler) Spec() *MetricSpec {
 func (h *GaugeVecHandler) Handle(m *Metric) error {
 	metric, err := h.GaugeVec.GetMetricWithLabelValues(m.LabelValues...)
 	if err != nil {
-		return err
+		return &MetricError{ReasonLabelMismatch, err}
 	}
 
 	switch m.Method {
-	default:
-		logger.Printf("Invalid gauge vec method %s for metric %s\n", m.Method, m.Name)
 	case "set":
 		metric.Set(m.Value)
 	case "inc":
@@ -150,7 +134,7 @@ func (h *GaugeVecHandler) Collector() prometheus.Collector {
 
 type HistogramHandler struct {
 	spec      *MetricSpec
-	Histogram prometheus.Histogram
+	Histogram *bucketHistogram
 }
 
 func (h *HistogramHandler) Spec() *MetricSpec {
@@ -158,7 +142,15 @@ func (h *HistogramHandler) Spec() *MetricSpec {
 }
 
 func (h *HistogramHandler) Handle(m *Metric) error {
-	h.Histogram.Observe(m.Value)
+	switch m.Method {
+	default:
+		return observe(h.Histogram, m)
+	case "observe_buckets":
+		if err := h.Histogram.ObserveBuckets(m.Buckets, m.BucketCounts, m.Sum, m.Count); err != nil {
+			return &MetricError{ReasonInvalidValue, err}
+		}
+	}
+
 	return nil
 }
 
@@ -168,7 +160,7 @@ func (h *HistogramHandler) Collector() prometheus.Collector {
 
 type HistogramVecHandler struct {
 	spec         *MetricSpec
-	HistogramVec *prometheus.HistogramVec
+	HistogramVec *bucketHistogramVec
 }
 
 func (h *HistogramVecHandler) Spec() *MetricSpec {
@@ -178,9 +170,18 @@ func (h *HistogramVecHandler) Spec() *MetricSpec {
 func (h *HistogramVecHandler) Handle(m *Metric) error {
 	metric, err := h.HistogramVec.GetMetricWithLabelValues(m.LabelValues...)
 	if err != nil {
-		return err
+		return &MetricError{ReasonLabelMismatch, err}
+	}
+
+	switch m.Method {
+	default:
+		return observe(metric, m)
+	case "observe_buckets":
+		if err := metric.ObserveBuckets(m.Buckets, m.BucketCounts, m.Sum, m.Count); err != nil {
+			return &MetricError{ReasonInvalidValue, err}
+		}
 	}
-	metric.Observe(m.Value)
+
 	return nil
 }
 
@@ -198,8 +199,7 @@ func (h *SummaryHandler) Spec() *MetricSpec {
 }
 
 func (h *SummaryHandler) Handle(m *Metric) error {
-	h.Summary.Observe(m.Value)
-	return nil
+	return observe(h.Summary, m)
 }
 
 func (h *SummaryHandler) Collector() prometheus.Collector {
@@ -218,12 +218,45 @@ func (h *SummaryVecHandler) Spec() *MetricSpec {
 func (h *SummaryVecHandler) Handle(m *Metric) error {
 	metric, err := h.SummaryVec.GetMetricWithLabelValues(m.LabelValues...)
 	if err != nil {
-		return err
+		return &MetricError{ReasonLabelMismatch, err}
 	}
-	metric.Observe(m.Value)
-	return nil
+	return observe(metric, m)
 }
 
 func (h *SummaryVecHandler) Collector() prometheus.Collector {
 	return h.SummaryVec
 }
+
+// maximum count of an observation by observers which record it once per
+// count, histograms record any count at once
+const maxObserveCount = 10000
+
+// observe records the value of m count times, or each of its values
+// for the observe_many method.
+func observe(o prometheus.Observer, m *Metric) error {
+	if m.Method == "observe_many" {
+		for _, v := range m.Values {
+			o.Observe(v)
+		}
+		return nil
+	}
+
+	n := m.Count
+	if n == 0 {
+		n = 1
+	}
+
+	if h, ok := o.(*bucketHistogram); ok {
+		h.ObserveN(m.Value, n)
+		return nil
+	}
+
+	if n > maxObserveCount {
+		return NewMetricError(ReasonInvalidValue, "Metric %s count %d is greater than %d", m.Name, n, maxObserveCount)
+	}
+	for i := uint64(0); i < n; i++ {
+		o.Observe(m.Value)
+	}
+
+	return nil
+}
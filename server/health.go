@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// readiness checks, all of which must pass for /readyz to succeed
+const (
+	CheckSocket    = "socket"
+	CheckSpecs     = "specs"
+	CheckProcessor = "processor"
+	CheckReload    = "reload"
+)
+
+var (
+	buildInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pmp_build_info",
+			Help: "Build information of the running prom_multi_proc, value is always 1",
+		},
+		[]string{"version", "build_hash", "go_version"},
+	)
+)
+
+// SetBuildInfo sets the labels of the pmp_build_info metric.
+func SetBuildInfo(version, buildHash, goVersion string) {
+	buildInfo.Reset()
+	buildInfo.WithLabelValues(version, buildHash, goVersion).Set(1)
+}
+
+type checks struct {
+	mu     sync.Mutex
+	status map[string]bool
+}
+
+func newChecks(names ...string) *checks {
+	c := &checks{status: make(map[string]bool)}
+	for _, name := range names {
+		c.status[name] = false
+	}
+	return c
+}
+
+func (c *checks) Set(name string, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.status[name] = ok
+}
+
+// Failing returns the names of the checks which are not passing.
+func (c *checks) Failing() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var result []string
+	for name, ok := range c.status {
+		if !ok {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// readyzHandler responds with 200 if every check of readiness passes,
+// otherwise with 503 and the checks which are not passing.
+func readyzHandler(readiness *checks) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		failing := readiness.Failing()
+		if len(failing) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			for _, name := range failing {
+				fmt.Fprintf(w, "%s: not ready\n", name)
+			}
+			return
+		}
+
+		fmt.Fprintln(w, "ok")
+	}
+}
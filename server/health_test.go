@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChecks(t *testing.T) {
+	c := newChecks("one", "two")
+
+	if failing := c.Failing(); !sliceEqStr(failing, []string{"one", "two"}) {
+		t.Errorf("Expected one and two to be failing, but got %v", failing)
+	}
+
+	c.Set("one", true)
+	if failing := c.Failing(); !sliceEqStr(failing, []string{"two"}) {
+		t.Errorf("Expected two to be failing, but got %v", failing)
+	}
+
+	c.Set("two", true)
+	if failing := c.Failing(); len(failing) != 0 {
+		t.Errorf("Expected no checks to be failing, but got %v", failing)
+	}
+}
+
+func TestReadyzHandler(t *testing.T) {
+	readiness := newChecks(CheckSocket, CheckSpecs, CheckProcessor, CheckReload)
+	for _, name := range []string{CheckSocket, CheckSpecs, CheckProcessor, CheckReload} {
+		readiness.Set(name, true)
+	}
+
+	w := httptest.NewRecorder()
+	readyzHandler(readiness)(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, but got %d", http.StatusOK, w.Code)
+	}
+
+	readiness.Set(CheckReload, false)
+	w = httptest.NewRecorder()
+	readyzHandler(readiness)(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, but got %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
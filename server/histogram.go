@@ -0,0 +1,178 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// bucketHistogram is a histogram which, unlike prometheus.Histogram,
+// can merge bucket counts that were already aggregated by the client.
+type bucketHistogram struct {
+	desc        *prometheus.Desc
+	upperBounds []float64
+	labelValues []string
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newBucketHistogram(desc *prometheus.Desc, buckets []float64, labelValues ...string) *bucketHistogram {
+	return &bucketHistogram{
+		desc:        desc,
+		upperBounds: buckets,
+		labelValues: labelValues,
+		counts:      make([]uint64, len(buckets)),
+	}
+}
+
+func (h *bucketHistogram) Observe(v float64) {
+	h.ObserveN(v, 1)
+}
+
+// ObserveN records the value v as if it had been observed n times.
+func (h *bucketHistogram) ObserveN(v float64, n uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := sort.SearchFloat64s(h.upperBounds, v)
+	if i < len(h.counts) {
+		h.counts[i] += n
+	}
+	h.count += n
+	h.sum += v * float64(n)
+}
+
+// ObserveBuckets merges cumulative bucket counts, along with their sum
+// and total count, into the histogram. The bucket upper bounds must be
+// exactly those the histogram was created with.
+func (h *bucketHistogram) ObserveBuckets(buckets []float64, counts []uint64, sum float64, count uint64) error {
+	if err := validateBucketCounts(h.upperBounds, buckets, counts, count); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var prev uint64
+	for i, c := range counts {
+		h.counts[i] += c - prev
+		prev = c
+	}
+	h.count += count
+	h.sum += sum
+
+	return nil
+}
+
+func (h *bucketHistogram) Describe(ch chan<- *prometheus.Desc) {
+	ch <- h.desc
+}
+
+func (h *bucketHistogram) Collect(ch chan<- prometheus.Metric) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buckets := make(map[float64]uint64, len(h.upperBounds))
+	var cumulative uint64
+	for i, upperBound := range h.upperBounds {
+		cumulative += h.counts[i]
+		buckets[upperBound] = cumulative
+	}
+
+	ch <- prometheus.MustNewConstHistogram(h.desc, h.count, h.sum, buckets, h.labelValues...)
+}
+
+// bucketHistogramVec bundles bucketHistograms which share a Desc but
+// differ in their label values.
+type bucketHistogramVec struct {
+	desc        *prometheus.Desc
+	upperBounds []float64
+	labelNames  []string
+
+	mu       sync.RWMutex
+	children map[string]*bucketHistogram
+}
+
+func newBucketHistogramVec(desc *prometheus.Desc, buckets []float64, labelNames []string) *bucketHistogramVec {
+	return &bucketHistogramVec{
+		desc:        desc,
+		upperBounds: buckets,
+		labelNames:  labelNames,
+		children:    make(map[string]*bucketHistogram),
+	}
+}
+
+func (v *bucketHistogramVec) GetMetricWithLabelValues(lvs ...string) (*bucketHistogram, error) {
+	if len(lvs) != len(v.labelNames) {
+		return nil, fmt.Errorf("%s: expected %d label values but got %d", v.desc, len(v.labelNames), len(lvs))
+	}
+
+	key := strings.Join(lvs, "\xff")
+
+	v.mu.RLock()
+	h, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return h, nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if h, ok = v.children[key]; !ok {
+		values := make([]string, len(lvs))
+		copy(values, lvs)
+		h = newBucketHistogram(v.desc, v.upperBounds, values...)
+		v.children[key] = h
+	}
+
+	return h, nil
+}
+
+func (v *bucketHistogramVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- v.desc
+}
+
+func (v *bucketHistogramVec) Collect(ch chan<- prometheus.Metric) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	for _, h := range v.children {
+		h.Collect(ch)
+	}
+}
+
+func validateBucketCounts(upperBounds, buckets []float64, counts []uint64, count uint64) error {
+	if len(buckets) != len(upperBounds) {
+		return fmt.Errorf("expected %d buckets but got %d", len(upperBounds), len(buckets))
+	}
+
+	for i := range buckets {
+		if buckets[i] != upperBounds[i] {
+			return fmt.Errorf("bucket %d upper bound %g does not match %g", i, buckets[i], upperBounds[i])
+		}
+	}
+
+	if len(counts) != len(buckets) {
+		return fmt.Errorf("expected %d bucket counts but got %d", len(buckets), len(counts))
+	}
+
+	for i := 1; i < len(counts); i++ {
+		if counts[i] < counts[i-1] {
+			return errors.New("bucket counts must be cumulative")
+		}
+	}
+
+	if len(counts) > 0 && counts[len(counts)-1] > count {
+		return errors.New("bucket counts cannot exceed total count")
+	}
+
+	return nil
+}
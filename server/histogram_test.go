@@ -0,0 +1,150 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func collectHistogram(t *testing.T, c prometheus.Collector) *dto.Histogram {
+	ch := make(chan prometheus.Metric, 1)
+	c.Collect(ch)
+	close(ch)
+
+	var out dto.Metric
+	if err := (<-ch).Write(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	return out.Histogram
+}
+
+func TestBucketHistogramObserveBuckets(t *testing.T) {
+	desc := prometheus.NewDesc("test_bucket_histogram", "Test bucket histogram", nil, nil)
+	h := newBucketHistogram(desc, []float64{0.1, 0.5, 1.0})
+
+	h.Observe(0.05)
+	h.Observe(0.7)
+
+	if err := h.ObserveBuckets([]float64{0.1, 0.5, 1.0}, []uint64{1, 3, 4}, 2.5, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	his := collectHistogram(t, h)
+
+	if his.GetSampleCount() != 7 {
+		t.Errorf("Expected sample count 7, but got %d", his.GetSampleCount())
+	}
+	if his.GetSampleSum() != 3.25 {
+		t.Errorf("Expected sample sum 3.25, but got %f", his.GetSampleSum())
+	}
+
+	expected := []uint64{2, 4, 6}
+	for i, b := range his.GetBucket() {
+		if b.GetCumulativeCount() != expected[i] {
+			t.Errorf("Expected bucket %g to have count %d, but got %d", b.GetUpperBound(), expected[i], b.GetCumulativeCount())
+		}
+	}
+}
+
+func TestBucketHistogramObserveBucketsFail(t *testing.T) {
+	desc := prometheus.NewDesc("test_bucket_histogram_fail", "Test bucket histogram", nil, nil)
+	h := newBucketHistogram(desc, []float64{0.1, 0.5, 1.0})
+
+	for _, tt := range []struct {
+		buckets []float64
+		counts  []uint64
+		count   uint64
+	}{
+		{[]float64{0.1, 0.5}, []uint64{1, 2}, 2},
+		{[]float64{0.1, 0.5, 2.0}, []uint64{1, 2, 3}, 3},
+		{[]float64{0.1, 0.5, 1.0}, []uint64{1, 2}, 2},
+		{[]float64{0.1, 0.5, 1.0}, []uint64{2, 1, 3}, 3},
+		{[]float64{0.1, 0.5, 1.0}, []uint64{1, 2, 3}, 2},
+	} {
+		if err := h.ObserveBuckets(tt.buckets, tt.counts, 1.0, tt.count); err == nil {
+			t.Errorf("ObserveBuckets(%v, %v, %d) expected error, but got none", tt.buckets, tt.counts, tt.count)
+		}
+	}
+
+	if his := collectHistogram(t, h); his.GetSampleCount() != 0 {
+		t.Errorf("Expected rejected buckets not to be merged, but got count %d", his.GetSampleCount())
+	}
+}
+
+func TestHistogramHandlerObserveCount(t *testing.T) {
+	SetTestLogger()
+	spec := &MetricSpec{
+		Type:    "histogram",
+		Name:    "test_histogram_observe_count",
+		Help:    "Test histogram observe count",
+		Buckets: []float64{1.0, 5.0},
+	}
+
+	handler, err := buildHandler(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range []Metric{
+		{Name: spec.Name, Method: "observe", Value: 0.5},
+		{Name: spec.Name, Method: "observe", Value: 2.0, Count: 10},
+		{Name: spec.Name, Method: "observe_many", Values: []float64{0.5, 3.0, 7.0}},
+	} {
+		if err := handler.Handle(&m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	his := collectHistogram(t, handler.Collector())
+
+	if his.GetSampleCount() != 14 {
+		t.Errorf("Expected sample count 14, but got %d", his.GetSampleCount())
+	}
+	if his.GetSampleSum() != 31.0 {
+		t.Errorf("Expected sample sum 31.0, but got %f", his.GetSampleSum())
+	}
+
+	expected := []uint64{2, 13}
+	for i, b := range his.GetBucket() {
+		if b.GetCumulativeCount() != expected[i] {
+			t.Errorf("Expected bucket %g to have count %d, but got %d", b.GetUpperBound(), expected[i], b.GetCumulativeCount())
+		}
+	}
+}
+
+func TestObserveCountLimit(t *testing.T) {
+	SetTestLogger()
+	for _, spec := range []*MetricSpec{
+		{Type: "summary", Name: "test_observe_count_limit", Help: "Test observe count limit"},
+		{Type: "summary", Name: "test_observe_count_limit_vec", Help: "Test observe count limit", Labels: []string{"a"}},
+	} {
+		handler, err := buildHandler(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m := &Metric{Name: spec.Name, Method: "observe", Value: 1, Count: 1 << 40, LabelValues: make([]string, len(spec.Labels))}
+		if err := handler.Handle(m); ErrorReason(err) != ReasonInvalidValue {
+			t.Errorf("Expected %s count %d to be rejected, but got %v", spec.Name, m.Count, err)
+		}
+
+		m.Count = maxObserveCount
+		if err := handler.Handle(m); err != nil {
+			t.Errorf("Expected %s count %d to be observed, but got %v", spec.Name, m.Count, err)
+		}
+	}
+
+	spec := &MetricSpec{Type: "histogram", Name: "test_observe_count_limit_histogram", Help: "Test observe count limit"}
+	handler, err := buildHandler(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.Handle(&Metric{Name: spec.Name, Method: "observe", Value: 1, Count: 1 << 40}); err != nil {
+		t.Errorf("Expected histogram to observe any count at once, but got %v", err)
+	}
+	if his := collectHistogram(t, handler.Collector()); his.GetSampleCount() != 1<<40 {
+		t.Errorf("Expected sample count %d, but got %d", uint64(1<<40), his.GetSampleCount())
+	}
+}
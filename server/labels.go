@@ -0,0 +1,178 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// policies for invalid label values
+const (
+	InvalidReject   = "reject"
+	InvalidTruncate = "truncate"
+	InvalidReplace  = "replace"
+)
+
+const defaultPlaceholder = "invalid"
+
+type labelValidator struct {
+	LabelRule
+	allowed map[string]bool
+	re      *regexp.Regexp
+}
+
+// compileLabelRules returns the validators of spec's labels by position,
+// labels without a rule only have their values checked for valid utf-8.
+func compileLabelRules(spec *MetricSpec) ([]*labelValidator, error) {
+	if len(spec.LabelRules) == 0 {
+		return nil, nil
+	}
+
+	// report problems in a stable order
+	var names []string
+	for name := range spec.LabelRules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]*labelValidator, len(spec.Labels))
+	for _, name := range names {
+		i := labelIndex(spec.Labels, name)
+		if i < 0 {
+			return nil, fmt.Errorf("Metric %s label rule for unknown label %s", spec.Name, name)
+		}
+
+		rule := spec.LabelRules[name]
+		if rule == nil {
+			continue
+		}
+		v := &labelValidator{LabelRule: *rule}
+
+		switch v.OnInvalid {
+		case "":
+			v.OnInvalid = InvalidReject
+		case InvalidReject, InvalidTruncate:
+		case InvalidReplace:
+			if v.Placeholder == "" {
+				v.Placeholder = defaultPlaceholder
+			}
+		default:
+			return nil, fmt.Errorf("Metric %s label %s has unknown on_invalid '%s', must be one of reject, truncate or replace", spec.Name, name, v.OnInvalid)
+		}
+
+		if v.MaxLength < 0 {
+			return nil, fmt.Errorf("Metric %s label %s max_length must not be negative", spec.Name, name)
+		}
+		if v.OnInvalid == InvalidTruncate && v.MaxLength == 0 {
+			return nil, fmt.Errorf("Metric %s label %s must have a max_length to truncate", spec.Name, name)
+		}
+
+		if len(v.AllowedValues) > 0 {
+			v.allowed = make(map[string]bool)
+			for _, value := range v.AllowedValues {
+				v.allowed[value] = true
+			}
+		}
+
+		if v.Pattern != "" {
+			re, err := regexp.Compile("^(?:" + v.Pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("Metric %s label %s has invalid pattern '%s': %s", spec.Name, name, v.Pattern, err)
+			}
+			v.re = re
+		}
+
+		result[i] = v
+	}
+
+	return result, nil
+}
+
+// validateLabelValues returns values with invalid values truncated or
+// replaced according to the validators, or an error if a value is
+// rejected.
+func validateLabelValues(validators []*labelValidator, labels, values []string) ([]string, error) {
+	if len(labels) != len(values) {
+		return values, nil
+	}
+
+	var result []string
+	for i, value := range values {
+		var v *labelValidator
+		if i < len(validators) {
+			v = validators[i]
+		}
+		checked, err := v.check(value)
+		if err != nil {
+			if len(value) > 64 {
+				value = value[:64] + "..."
+			}
+			return nil, NewMetricError(ReasonInvalidLabel, "Label %s value %q %s", labels[i], value, err)
+		}
+		if checked != value {
+			if result == nil {
+				result = append([]string(nil), values...)
+			}
+			result[i] = checked
+		}
+	}
+
+	if result == nil {
+		return values, nil
+	}
+	return result, nil
+}
+
+// check returns value if it is valid, otherwise its replacement or an
+// error describing the problem. A nil validator only checks utf-8.
+func (v *labelValidator) check(value string) (string, error) {
+	if v == nil {
+		if !utf8.ValidString(value) {
+			return "", errors.New("is not valid utf-8")
+		}
+		return value, nil
+	}
+
+	if !utf8.ValidString(value) {
+		switch v.OnInvalid {
+		case InvalidReplace:
+			return v.Placeholder, nil
+		case InvalidTruncate:
+			value = strings.ToValidUTF8(value, "")
+		default:
+			return "", errors.New("is not valid utf-8")
+		}
+	}
+
+	if v.MaxLength > 0 && utf8.RuneCountInString(value) > v.MaxLength {
+		switch v.OnInvalid {
+		case InvalidReplace:
+			return v.Placeholder, nil
+		case InvalidTruncate:
+			value = string([]rune(value)[:v.MaxLength])
+		default:
+			return "", fmt.Errorf("is longer than %d", v.MaxLength)
+		}
+	}
+
+	var problem string
+	switch {
+	case v.allowed != nil && !v.allowed[value]:
+		problem = "is not allowed"
+	case v.re != nil && !v.re.MatchString(value):
+		problem = fmt.Sprintf("does not match %s", v.Pattern)
+	case value == "" && v.allowed == nil && v.re == nil:
+		problem = "is empty"
+	}
+	if problem == "" {
+		return value, nil
+	}
+
+	if v.OnInvalid == InvalidReplace {
+		return v.Placeholder, nil
+	}
+	return "", errors.New(problem)
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateLabelValues(t *testing.T) {
+	spec := &MetricSpec{
+		Name:   "test_labels",
+		Labels: []string{"method", "code", "path", "user", "free"},
+		LabelRules: map[string]*LabelRule{
+			"method": {AllowedValues: []string{"get", "post"}, OnInvalid: "replace"},
+			"code":   {Pattern: "[1-5][0-9][0-9]"},
+			"path":   {MaxLength: 5, OnInvalid: "truncate"},
+			"user":   {MaxLength: 3},
+		},
+	}
+	validators, err := compileLabelRules(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		in  []string
+		out []string
+		err bool
+	}{
+		{[]string{"get", "200", "/", "bob", ""}, []string{"get", "200", "/", "bob", ""}, false},
+		{[]string{"PURGE", "200", "/", "bob", "x"}, []string{"invalid", "200", "/", "bob", "x"}, false},
+		{[]string{"get", "200", "/users/1", "bob", "x"}, []string{"get", "200", "/user", "bob", "x"}, false},
+		{[]string{"get", "200", "/ü\xffüüüü", "bob", "x"}, []string{"get", "200", "/üüüü", "bob", "x"}, false},
+		{[]string{"get", "600", "/", "bob", "x"}, nil, true},
+		{[]string{"get", "200", "/", "alice", "x"}, nil, true},
+		{[]string{"get", "200", "/", "", "x"}, nil, true},
+		{[]string{"get", "200", "/", "bob", "\xff"}, nil, true},
+	} {
+		out, err := validateLabelValues(validators, spec.Labels, tt.in)
+		if (err != nil) != tt.err || !sliceEqStr(out, tt.out) {
+			t.Errorf("validateLabelValues(%q) => %q %v, want %q", tt.in, out, err, tt.out)
+		}
+		if err != nil && ErrorReason(err) != ReasonInvalidLabel {
+			t.Errorf("Expected reason %s, but got %s", ReasonInvalidLabel, ErrorReason(err))
+		}
+	}
+
+	// labels without rules are only checked for utf-8
+	if _, err := validateLabelValues(nil, []string{"a"}, []string{strings.Repeat("a", 10000)}); err != nil {
+		t.Error(err)
+	}
+	if _, err := validateLabelValues(nil, []string{"a"}, []string{"\xff"}); err == nil {
+		t.Error("Expected invalid utf-8 to be rejected")
+	}
+}
+
+func TestCompileLabelRulesFail(t *testing.T) {
+	for _, rule := range []*LabelRule{
+		{OnInvalid: "ignore"},
+		{OnInvalid: "truncate"},
+		{MaxLength: -1},
+		{Pattern: "("},
+	} {
+		spec := &MetricSpec{Name: "test_labels", Labels: []string{"a"}, LabelRules: map[string]*LabelRule{"a": rule}}
+		if _, err := compileLabelRules(spec); err == nil {
+			t.Errorf("compileLabelRules(%+v) => nil, want error", rule)
+		}
+	}
+
+	spec := &MetricSpec{Name: "test_labels", Labels: []string{"a"}, LabelRules: map[string]*LabelRule{"b": {}}}
+	if _, err := compileLabelRules(spec); err == nil {
+		t.Error("Expected label rule for unknown label to fail")
+	}
+}
+
+func TestRegistryLabelRules(t *testing.T) {
+	SetTestLogger()
+	registry := NewRegistry()
+
+	spec := &MetricSpec{Type: "histogram", Name: "test_label_rules_seconds", Help: "Label rules", Labels: []string{"method"},
+		Relabel:    []RelabelRule{{Label: "method", Action: "lowercase"}},
+		LabelRules: map[string]*LabelRule{"method": {AllowedValues: []string{"get"}}}}
+	if err := registry.Register(spec); err != nil {
+		t.Fatal(err)
+	}
+	defer registry.Unregister(spec.Name)
+
+	// relabel rules are applied before label values are validated
+	if err := registry.Handle(&Metric{Name: spec.Name, Method: "observe", LabelValues: []string{"GET"}}); err != nil {
+		t.Fatal(err)
+	}
+	err := registry.Handle(&Metric{Name: spec.Name, Method: "observe", LabelValues: []string{"POST"}})
+	if ErrorReason(err) != ReasonInvalidLabel {
+		t.Errorf("Expected invalid label value, but got %v", err)
+	}
+
+	series, err := registry.Series(spec.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 1 || series[0]["method"] != "get" {
+		t.Errorf("Expected a single series, but got %v", series)
+	}
+}
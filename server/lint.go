@@ -0,0 +1,177 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// LintProblem is a problem found with a metric spec. Errors prevent the
+// metric from being registered, warnings are against conventions.
+type LintProblem struct {
+	File     string
+	Name     string
+	Severity string
+	Message  string
+}
+
+func (p LintProblem) String() string {
+	if p.Name == "" {
+		return fmt.Sprintf("%s: %s: %s", p.File, p.Severity, p.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s: %s", p.File, p.Name, p.Severity, p.Message)
+}
+
+// LintSpecs returns every problem found with the metric specs in path,
+// which like -metrics may be a file, directory or glob.
+func LintSpecs(path string) ([]LintProblem, error) {
+	files, _, err := specFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		problems []LintProblem
+		defined  = make(map[string]string)
+	)
+
+	for _, file := range files {
+		specs, err := loadSpecFile(file, ioutil.Discard)
+		if err != nil {
+			problems = append(problems, LintProblem{file, "", SeverityError, err.Error()})
+			continue
+		}
+
+		for i, spec := range specs {
+			name := spec.Name
+			if name == "" {
+				name = "#" + strconv.Itoa(i)
+			}
+
+			if other, ok := defined[spec.Name]; ok && spec.Name != "" {
+				problems = append(problems, LintProblem{file, name, SeverityError, fmt.Sprintf("Duplicate metric, also defined in %s", other)})
+			} else {
+				defined[spec.Name] = file
+			}
+
+			for _, err := range specErrors(spec) {
+				problems = append(problems, LintProblem{file, name, SeverityError, err.Error()})
+			}
+			for _, warning := range specWarnings(spec) {
+				problems = append(problems, LintProblem{file, name, SeverityWarning, warning})
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// specErrors returns every problem which prevents spec from being
+// registered.
+func specErrors(spec *MetricSpec) []error {
+	var errs []error
+
+	if err := validateMetric(spec.Name); err != nil {
+		errs = append(errs, err)
+	}
+
+	var reserved []string
+	switch spec.Type {
+	default:
+		if _, ok := lookupHandlerType(spec.Type); !ok {
+			types := HandlerTypes()
+			last := len(types) - 1
+			errs = append(errs, fmt.Errorf("Unknown type '%s', must be one of %s or %s", spec.Type, strings.Join(types[:last], ", "), types[last]))
+			break
+		}
+		// the factory of a custom type validates its own specs
+		if _, err := buildHandler(spec); err != nil {
+			errs = append(errs, err)
+		}
+		errs = append(errs, relabelErrors(spec)...)
+		if _, err := compileLabelRules(spec); err != nil {
+			errs = append(errs, err)
+		}
+		errs = append(errs, valueErrors(spec)...)
+		return errs
+	case "counter", "gauge":
+	case "histogram":
+		reserved = append(reserved, "le")
+		if _, err := specBuckets(spec); err != nil {
+			errs = append(errs, err)
+		}
+	case "summary":
+		reserved = append(reserved, "quantile")
+		var keys []string
+		for key := range spec.Objectives {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		seen := make(map[float64]string)
+		for _, key := range keys {
+			objectives, err := validateObjectives(map[string]float64{key: spec.Objectives[key]})
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			for q := range objectives {
+				if other, ok := seen[q]; ok {
+					errs = append(errs, fmt.Errorf("Duplicate objective quantile found: %s and %s", other, key))
+				}
+				seen[q] = key
+			}
+		}
+		if _, err := specMaxAge(spec); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	errs = append(errs, labelErrors(spec.Labels, reserved...)...)
+	errs = append(errs, relabelErrors(spec)...)
+	if _, err := compileLabelRules(spec); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, valueErrors(spec)...)
+
+	return errs
+}
+
+// specWarnings returns problems with spec which go against prometheus
+// naming conventions, or settings which have no effect.
+func specWarnings(spec *MetricSpec) []string {
+	var warnings []string
+
+	if spec.Help == "" {
+		warnings = append(warnings, "Help is empty")
+	}
+
+	if spec.Type == "counter" && !strings.HasSuffix(spec.Name, "_total") {
+		warnings = append(warnings, "Counter names should end in _total")
+	}
+	if spec.Type != "counter" && strings.HasSuffix(spec.Name, "_total") {
+		warnings = append(warnings, "Only counter names should end in _total")
+	}
+
+	for _, suffix := range []string{"_bucket", "_count", "_sum"} {
+		if (spec.Type == "histogram" || spec.Type == "summary") && strings.HasSuffix(spec.Name, suffix) {
+			warnings = append(warnings, fmt.Sprintf("Name should not end in %s, it is added to %s series", suffix, spec.Type))
+		}
+	}
+
+	if spec.Type != "histogram" && (len(spec.Buckets) > 0 || spec.BucketSpec != nil) {
+		warnings = append(warnings, "Buckets are only used by histograms")
+	}
+	if spec.Type != "summary" && (len(spec.Objectives) > 0 || spec.MaxAge != "" || spec.AgeBuckets > 0 || spec.BufCap > 0) {
+		warnings = append(warnings, "Objectives, max_age, age_buckets and buf_cap are only used by summaries")
+	}
+
+	return warnings
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLintSpecs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestFile(t, dir, "a.json", `[
+		{"type": "counter", "name": "requests_total", "help": "Requests", "labels": ["code", "__code", "code", "9"]},
+		{"type": "histogram", "name": "latency_seconds", "help": "Latency", "labels": ["le"], "buckets": [1, 0.5]},
+		{"type": "summary", "name": "size_bytes", "help": "Size", "labels": ["quantile"], "objectives": {"2": 0.1, "0.5": 0.05, "0.50": 0.01}},
+		{"type": "gauge", "name": "bad-name", "help": "Bad"},
+		{"type": "nope", "name": "nope", "help": "Nope"},
+		{"type": "counter", "name": "requests", "help": ""}
+	]`)
+	writeTestFile(t, dir, "b.json", `[{"type": "counter", "name": "requests_total", "help": "Requests"}]`)
+
+	problems, err := LintSpecs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out []string
+	for _, p := range problems {
+		out = append(out, strings.TrimPrefix(p.String(), dir+"/"))
+	}
+
+	expected := []string{
+		"a.json: requests_total: error: Label name '__code' is reserved, names beginning with __ are for internal use",
+		"a.json: requests_total: error: Duplicate label found: code",
+		"a.json: requests_total: error: Label name '9' is not valid",
+		"a.json: latency_seconds: error: Metric latency_seconds: buckets must be strictly increasing: 1 >= 0.5",
+		"a.json: latency_seconds: error: Label name 'le' is reserved for this metric type",
+		"a.json: size_bytes: error: Duplicate objective quantile found: 0.5 and 0.50",
+		"a.json: size_bytes: error: Objective quantile 2 must be between 0 and 1",
+		"a.json: size_bytes: error: Label name 'quantile' is reserved for this metric type",
+		"a.json: bad-name: error: Metric name 'bad-name' is not valid",
+		"a.json: nope: error: Unknown type 'nope', must be one of counter, gauge, histogram or summary",
+		"a.json: requests: warning: Help is empty",
+		"a.json: requests: warning: Counter names should end in _total",
+		"b.json: requests_total: error: Duplicate metric, also defined in " + dir + "/a.json",
+	}
+
+	if !sliceEqStr(out, expected) {
+		t.Errorf("Expected problems:\n%s\nbut got:\n%s", strings.Join(expected, "\n"), strings.Join(out, "\n"))
+	}
+}
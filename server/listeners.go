@@ -0,0 +1,215 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Listener is a unix socket which metrics are read from in addition to
+// Options.Socket, so that apps sharing a server can't overwrite each
+// other's metrics.
+type Listener struct {
+	// path of the unix socket
+	Socket string `json:"socket"`
+
+	// prepended to the name of every metric read from the socket
+	Prefix string `json:"prefix"`
+
+	// label values set on every metric read from the socket, overriding
+	// any values sent for them
+	Labels map[string]string `json:"labels"`
+
+	// anchored regexes of the metric names, without the prefix, which may
+	// be sent to the socket, every name may be sent if empty
+	Allow []string `json:"allow"`
+
+	// tenant of every metric read from the socket, otherwise the tenant
+	// sent with each metric is used
+	Tenant string `json:"tenant"`
+}
+
+type listener struct {
+	Listener
+	allow []*regexp.Regexp
+
+	// prefixes of every listener, a name is in the namespace of the
+	// listener with the longest of them it starts with
+	prefixes []string
+
+	// tenants of every listener, which may only be sent to on their
+	// sockets
+	tenants []string
+}
+
+// compileListeners validates and compiles listeners, along with the
+// listener of socket which comes first. None may use socket and their
+// tenants must be one of tenants.
+func compileListeners(socket string, listeners []Listener, tenants []string) ([]*listener, error) {
+	result := []*listener{{Listener: Listener{Socket: socket}}}
+
+	sockets := []string{socket}
+	for i, l := range listeners {
+		if l.Socket == "" {
+			return nil, fmt.Errorf("Listener %d must have a socket", i+1)
+		}
+		if sliceContainsStr(sockets, l.Socket) {
+			return nil, fmt.Errorf("Listener %d socket %s is already used", i+1, l.Socket)
+		}
+		sockets = append(sockets, l.Socket)
+
+		if l.Prefix != "" && !metricRe.MatchString(l.Prefix) {
+			return nil, fmt.Errorf("Listener %d prefix '%s' is not valid", i+1, l.Prefix)
+		}
+
+		var labels []string
+		for label := range l.Labels {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		if err := validateLabels(labels); err != nil {
+			return nil, fmt.Errorf("Listener %d: %s", i+1, err)
+		}
+
+		if l.Tenant != "" && !sliceContainsStr(tenants, l.Tenant) {
+			return nil, fmt.Errorf("Listener %d has unknown tenant %s", i+1, l.Tenant)
+		}
+
+		c := &listener{Listener: l}
+		for _, expr := range l.Allow {
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("Listener %d has invalid allow regex '%s': %s", i+1, expr, err)
+			}
+			c.allow = append(c.allow, re)
+		}
+
+		result = append(result, c)
+	}
+
+	var prefixes, bound []string
+	for _, l := range result {
+		if l.Prefix != "" {
+			prefixes = append(prefixes, l.Prefix)
+		}
+		if l.Tenant != "" {
+			bound = append(bound, l.Tenant)
+		}
+	}
+	for _, l := range result {
+		l.prefixes = prefixes
+		l.tenants = bound
+	}
+
+	return result, nil
+}
+
+// apply checks that metric may be sent to the socket of l, then prefixes
+// its name and sets its tenant. Names in the namespace of
+// another listener, and tenants of another listener, are not allowed. A
+// nil listener accepts every metric.
+func (l *listener) apply(metric *Metric) error {
+	if l == nil {
+		return nil
+	}
+
+	if len(l.allow) > 0 {
+		allowed := false
+		for _, re := range l.allow {
+			if re.MatchString(metric.Name) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return NewMetricError(ReasonNotAllowed, "Metric %s is not allowed on socket %s", metric.Name, l.Socket)
+		}
+	}
+
+	name := l.Prefix + metric.Name
+	if namespace(l.prefixes, name) != l.Prefix {
+		return NewMetricError(ReasonNotAllowed, "Metric %s is in the namespace of another socket, not allowed on socket %s", name, l.Socket)
+	}
+
+	if l.Tenant == "" && sliceContainsStr(l.tenants, metric.Tenant) {
+		return NewMetricError(ReasonNotAllowed, "Tenant %s of metric %s belongs to another socket, not allowed on socket %s", metric.Tenant, name, l.Socket)
+	}
+
+	metric.Name = name
+	if l.Tenant != "" {
+		metric.Tenant = l.Tenant
+	}
+
+	return nil
+}
+
+// constLabels returns the label values by name which l sets on the
+// metrics read from its socket.
+func (l *listener) constLabels() map[string]string {
+	if l == nil {
+		return nil
+	}
+	return l.Labels
+}
+
+// namespace returns the longest of prefixes which name starts with, or
+// an empty string if it has none of them.
+func namespace(prefixes []string, name string) string {
+	var result string
+	for _, prefix := range prefixes {
+		if len(prefix) > len(result) && strings.HasPrefix(name, prefix) {
+			result = prefix
+		}
+	}
+	return result
+}
+
+// withConstLabels returns the label values of a metric with labels,
+// including the values of its const labels. Values may be sent for every
+// label, in which case the const labels override them, or for every label
+// except the const labels.
+func withConstLabels(labels, values []string, consts map[string]string) []string {
+	if len(consts) == 0 {
+		return values
+	}
+
+	if len(values) == len(labels) {
+		var result []string
+		for i, label := range labels {
+			if v, ok := consts[label]; ok && values[i] != v {
+				if result == nil {
+					result = append([]string(nil), values...)
+				}
+				result[i] = v
+			}
+		}
+		if result == nil {
+			return values
+		}
+		return result
+	}
+
+	n := 0
+	for _, label := range labels {
+		if _, ok := consts[label]; ok {
+			n++
+		}
+	}
+	if len(values) != len(labels)-n {
+		// reported as a label mismatch when handled
+		return values
+	}
+
+	result := make([]string, 0, len(labels))
+	for _, label := range labels {
+		if v, ok := consts[label]; ok {
+			result = append(result, v)
+		} else {
+			result = append(result, values[0])
+			values = values[1:]
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atongen/prom_multi_proc/client"
+)
+
+func TestCompileListenersFail(t *testing.T) {
+	for _, listeners := range [][]Listener{
+		{{Prefix: "app_"}},
+		{{Socket: "/tmp/default.sock"}},
+		{{Socket: "/tmp/a.sock"}, {Socket: "/tmp/a.sock"}},
+		{{Socket: "/tmp/a.sock", Prefix: "app-"}},
+		{{Socket: "/tmp/a.sock", Labels: map[string]string{"__app": "a"}}},
+		{{Socket: "/tmp/a.sock", Allow: []string{"("}}},
+		{{Socket: "/tmp/a.sock", Tenant: "nope"}},
+	} {
+		if _, err := compileListeners("/tmp/default.sock", listeners, []string{"billing"}); err == nil {
+			t.Errorf("compileListeners(%+v) => nil, want error", listeners)
+		}
+	}
+}
+
+func TestListenerApply(t *testing.T) {
+	listeners, err := compileListeners("", []Listener{{
+		Socket: "/tmp/a.sock",
+		Prefix: "a_",
+		Labels: map[string]string{"app": "a"},
+		Allow:  []string{"jobs_.*"},
+		Tenant: "billing",
+	}}, []string{"billing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := listeners[1]
+
+	m := Metric{Name: "jobs_total"}
+	if err := l.apply(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "a_jobs_total" || m.Tenant != "billing" {
+		t.Errorf("Expected metric to be prefixed and of tenant billing, but got %+v", m)
+	}
+	if consts := l.constLabels(); consts["app"] != "a" {
+		t.Errorf("Expected const labels of listener, but got %v", consts)
+	}
+
+	m = Metric{Name: "other_total"}
+	if err := l.apply(&m); ErrorReason(err) != ReasonNotAllowed {
+		t.Errorf("Expected metric to not be allowed, but got %v", err)
+	}
+
+	m = Metric{Name: "a_jobs_total"}
+	if err := listeners[0].apply(&m); ErrorReason(err) != ReasonNotAllowed {
+		t.Errorf("Expected metric in namespace of listener to not be allowed on default socket, but got %v", err)
+	}
+
+	m = Metric{Name: "jobs_total", Tenant: "billing"}
+	if err := listeners[0].apply(&m); ErrorReason(err) != ReasonNotAllowed {
+		t.Errorf("Expected tenant of listener to not be allowed on default socket, but got %v", err)
+	}
+
+	m = Metric{Name: "jobs_total"}
+	if err := listeners[0].apply(&m); err != nil || m.Name != "jobs_total" {
+		t.Errorf("Expected default socket to accept metric, but got %+v %v", m, err)
+	}
+
+	var none *listener
+	m = Metric{Name: "other_total"}
+	if err := none.apply(&m); err != nil || m.Name != "other_total" {
+		t.Errorf("Expected nil listener to accept metric, but got %+v %v", m, err)
+	}
+}
+
+func TestWithConstLabels(t *testing.T) {
+	labels := []string{"app", "status", "host"}
+	consts := map[string]string{"app": "a", "host": "h"}
+
+	for _, tt := range []struct {
+		in  []string
+		out []string
+	}{
+		{[]string{"ok"}, []string{"a", "ok", "h"}},
+		{[]string{"b", "ok", "h"}, []string{"a", "ok", "h"}},
+		{[]string{"ok", "extra"}, []string{"ok", "extra"}},
+		{nil, nil},
+	} {
+		if out := withConstLabels(labels, tt.in, consts); !sliceEqStr(out, tt.out) {
+			t.Errorf("withConstLabels(%q) => %q, want %q", tt.in, out, tt.out)
+		}
+	}
+
+	if out := withConstLabels(labels, []string{"x"}, nil); !sliceEqStr(out, []string{"x"}) {
+		t.Errorf("withConstLabels without const labels => %q, want values unchanged", out)
+	}
+}
+
+func TestServerListeners(t *testing.T) {
+	SetTestLogger()
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "default.sock")
+	appSocket := filepath.Join(dir, "app.sock")
+	file := writeTestFile(t, dir, "metrics.json", `[
+		{"type": "counter", "name": "test_listener_jobs_total", "help": "Jobs", "labels": ["app", "status"]},
+		{"type": "counter", "name": "test_listener_app_jobs_total", "help": "Jobs", "labels": ["app", "status"]}
+	]`)
+
+	s := NewServer(Options{
+		Socket:  socket,
+		Metrics: file,
+		Listeners: []Listener{{
+			Socket: appSocket,
+			Prefix: "test_listener_app_",
+			Labels: map[string]string{"app": "one"},
+			Allow:  []string{"jobs_total"},
+		}},
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Run(context.Background())
+	}()
+
+	eventually(t, "Expected metrics to be registered", func() bool {
+		return len(s.Registry().Names()) == 2
+	})
+
+	// the default socket may not write into the namespace of a listener
+	c := client.New(client.Options{Socket: socket})
+	c.Inc("test_listener_app_jobs_total", "default", "ok")
+	c.Inc("test_listener_jobs_total", "default", "ok")
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c = client.New(client.Options{Socket: appSocket})
+	c.Inc("jobs_total", "ok")
+	c.Inc("jobs_total", "two", "ok")
+	c.Inc("test_listener_jobs_total", "ok")
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "Expected metrics to be handled", func() bool {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		body := rec.Body.String()
+		return strings.Contains(body, `test_listener_jobs_total{app="default",status="ok"} 1`) &&
+			strings.Contains(body, `test_listener_app_jobs_total{app="one",status="ok"} 2`)
+	})
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if body := rec.Body.String(); strings.Contains(body, `test_listener_app_jobs_total{app="default"`) {
+		t.Errorf("Expected default socket to not write into namespace of listener, but got %s", body)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(appSocket); !os.IsNotExist(err) {
+		t.Errorf("Expected socket to be removed, but got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/atongen/prom_multi_proc/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	logCloser io.WriteCloser
+	logger    = log.New(os.Stdout, "", log.LstdFlags)
+
+	metricsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pmp_metrics_total",
+			Help: "Total count of metrics processed by status",
+		},
+		[]string{"status", "reason"},
+	)
+)
+
+// the wire format is shared with the client package
+type (
+	MetricSpec            = client.MetricSpec
+	BucketSpec            = client.BucketSpec
+	ExponentialBucketSpec = client.ExponentialBucketSpec
+	LinearBucketSpec      = client.LinearBucketSpec
+	RelabelRule           = client.RelabelRule
+	LabelRule             = client.LabelRule
+	Metric                = client.Metric
+)
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func init() {
+	prometheus.MustRegister(metricsTotal)
+	prometheus.MustRegister(metricSamplesTotal)
+	prometheus.MustRegister(parseDuration)
+	prometheus.MustRegister(handleDuration)
+	prometheus.MustRegister(unknownMetricNames)
+	prometheus.MustRegister(buildInfo)
+	prometheus.MustRegister(configLastReloadSuccessful)
+	prometheus.MustRegister(configLastReloadSuccessTimestamp)
+	prometheus.MustRegister(configHash)
+	prometheus.MustRegister(configLastReloadMetrics)
+}
+
+func CountMetric(status string) {
+	metricsTotal.WithLabelValues(status, "").Inc()
+}
+
+func CountError(err error) {
+	metricsTotal.WithLabelValues("error", ErrorReason(err)).Inc()
+}
+
+// CountWarning counts a metric which was handled despite err.
+func CountWarning(err error) {
+	metricsTotal.WithLabelValues("warning", ErrorReason(err)).Inc()
+}
+
+func SetLogger(file string) error {
+	if logCloser != nil {
+		logCloser.Close()
+	}
+	var err error
+	if file == "" {
+		var b bytes.Buffer
+		logCloser = nopCloser{&b}
+		logger = log.New(os.Stdout, "", log.LstdFlags)
+	} else {
+		logCloser, err = os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("Error opening log file (%s): %s", file, err)
+		}
+		logger = log.New(logCloser, "", log.LstdFlags)
+	}
+	return nil
+}
+
+// Logger returns the logger set by SetLogger, which writes to STDOUT by
+// default.
+func Logger() *log.Logger {
+	return logger
+}
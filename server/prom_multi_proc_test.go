@@ -1,7 +1,8 @@
-package main
+package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -298,8 +299,8 @@ func TestMetrics5Multi(t *testing.T) {
 	SetTestLogger()
 	specs := getTestSpecs(t, 5)
 
-	metricCh := make(chan Metric)
-	dataCh := make(chan []byte)
+	metricCh := make(chan socketMetric)
+	dataCh := make(chan socketData)
 
 	registry := NewRegistry()
 
@@ -310,7 +311,9 @@ func TestMetrics5Multi(t *testing.T) {
 		}
 	}
 
-	go DataParser(dataCh, metricCh)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewServer(Options{Registry: registry}).parseData(ctx, dataCh, metricCh)
 
 	data := []Metric{
 		Metric{
@@ -335,7 +338,7 @@ func TestMetrics5Multi(t *testing.T) {
 	}
 
 	go func() {
-		dataCh <- b
+		dataCh <- socketData{data: b}
 	}()
 
 	for i := 0; i < 2; i++ {
@@ -372,3 +375,102 @@ func TestMetrics5Multi(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateObjectives(t *testing.T) {
+	for _, tt := range []struct {
+		objectives map[string]float64
+		valid      bool
+	}{
+		{map[string]float64{"0.5": 0.05, "0.99": 0.001}, true},
+		{map[string]float64{"nope": 0.05}, false},
+		{map[string]float64{"1.5": 0.05}, false},
+		{map[string]float64{"-0.5": 0.05}, false},
+		{map[string]float64{"0.999": 0.005}, true},
+		{map[string]float64{"0": 0, "1": 0}, true},
+		{map[string]float64{"0.9": 0.2}, true},
+		{map[string]float64{"0.5": -0.01}, false},
+		{map[string]float64{"0.5": 1.5}, false},
+		{map[string]float64{"0.5": 0.05, "0.50": 0.01}, false},
+	} {
+		_, err := validateObjectives(tt.objectives)
+		if tt.valid && err != nil {
+			t.Errorf("validateObjectives(%v) unexpected error: %s", tt.objectives, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("validateObjectives(%v) expected error, but got none", tt.objectives)
+		}
+	}
+}
+
+func TestSummaryMaxAge(t *testing.T) {
+	spec := &MetricSpec{
+		Type:       "summary",
+		Name:       "test_summary_max_age_ok",
+		Help:       "Test summary max age",
+		Objectives: map[string]float64{"0.5": 0.05},
+		MaxAge:     "5m",
+		AgeBuckets: 3,
+		BufCap:     100,
+	}
+	if _, err := buildHandler(spec); err != nil {
+		t.Errorf("Expected max_age, age_buckets and buf_cap to be valid, but got %s", err)
+	}
+}
+
+func TestSummaryMaxAgeFail(t *testing.T) {
+	for _, maxAge := range []string{"forever", "-1m", "0s"} {
+		spec := &MetricSpec{
+			Type:   "summary",
+			Name:   "test_summary_max_age",
+			Help:   "Test summary max age",
+			MaxAge: maxAge,
+		}
+		if _, err := buildHandler(spec); err == nil {
+			t.Errorf("Expected max_age %s to throw error, but did not", maxAge)
+		}
+	}
+}
+
+func TestMetrics6Strict(t *testing.T) {
+	SetTestLogger()
+
+	for i, strict := range []bool{false, true} {
+		specs := getTestSpecs(t, 6+i)
+
+		var registry Registry
+		if strict {
+			registry = NewStrictRegistry()
+		} else {
+			registry = NewRegistry()
+		}
+		for _, spec := range specs {
+			if err := registry.Register(spec); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, tt := range []struct {
+			metric     Metric
+			reason     string
+			strictOnly bool
+		}{
+			{Metric{Name: "counter", Method: "observe"}, ReasonBadMethod, true},
+			{Metric{Name: "histogram", Method: "set"}, ReasonBadMethod, true},
+			{Metric{Name: "nope", Method: "inc"}, ReasonUnknownMetric, false},
+			{Metric{Name: "counter", Method: "add", Value: -1}, ReasonNegativeCounter, false},
+			{Metric{Name: "counter_vec", Method: "inc", LabelValues: []string{"a"}}, ReasonLabelMismatch, false},
+		} {
+			tt.metric.Name = fmt.Sprintf("test_%d_%s", 6+i, tt.metric.Name)
+			err := registry.Handle(&tt.metric)
+			if tt.strictOnly && !strict {
+				if !IsWarning(err) || ErrorReason(err) != tt.reason {
+					t.Errorf("Expected %+v to be handled with %s warning, but got %v", tt.metric, tt.reason, err)
+				}
+				continue
+			}
+			if reason := ErrorReason(err); err == nil || reason != tt.reason {
+				t.Errorf("Expected %+v to throw %s error, but got %v (%s)", tt.metric, tt.reason, err, reason)
+			}
+		}
+	}
+}
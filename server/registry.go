@@ -0,0 +1,492 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	metricRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelRe  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+	defaultBuckets = []float64{
+		0.005,
+		0.01,
+		0.025,
+		0.05,
+		0.1,
+		0.25,
+		0.5,
+		1.0,
+		2.5,
+		5.0,
+		10.0,
+	}
+	defaultObjectives = map[float64]float64{
+		0.5:  0.05,
+		0.9:  0.01,
+		0.99: 0.001,
+	}
+)
+
+type ireg struct {
+	Handlers map[string]MetricHandler
+	strict   bool
+	mu       sync.Mutex
+
+	// global relabel rules, and those of each metric
+	relabel     []*relabeler
+	specRelabel map[string][]*relabeler
+
+	// label value validators of each metric
+	specLabels map[string][]*labelValidator
+
+	// automatic registration of unknown metrics, and the number of
+	// metrics registered by it
+	auto      *autoRegisterer
+	autoCount int
+
+	// prometheus registry of a tenant, the default registry if nil
+	prom *prometheus.Registry
+}
+
+type Registry interface {
+	Names() []string
+	Specs() []*MetricSpec
+	Series(string) ([]map[string]string, error)
+	Register(*MetricSpec) error
+	Unregister(string) error
+	Handle(*Metric) error
+	HandleWithConstLabels(*Metric, map[string]string) error
+	SetRelabelRules([]RelabelRule) error
+	SetAutoRegister(*AutoRegister) error
+	Gatherer() prometheus.Gatherer
+}
+
+func NewRegistry() Registry {
+	return &ireg{
+		Handlers:    make(map[string]MetricHandler),
+		specRelabel: make(map[string][]*relabeler),
+		specLabels:  make(map[string][]*labelValidator),
+	}
+}
+
+// NewStrictRegistry returns a Registry which rejects metrics sent with a
+// method that is not valid for their type, rather than logging them.
+func NewStrictRegistry() Registry {
+	r := NewRegistry().(*ireg)
+	r.strict = true
+	return r
+}
+
+// NewTenantRegistry returns a Registry whose metrics are registered with
+// a prometheus registry of their own, rather than the default registry,
+// so that they can be exposed separately.
+func NewTenantRegistry(strict bool) Registry {
+	r := NewRegistry().(*ireg)
+	r.strict = strict
+	r.prom = prometheus.NewRegistry()
+	return r
+}
+
+// Gatherer returns the prometheus registry metrics are registered with.
+func (r *ireg) Gatherer() prometheus.Gatherer {
+	if r.prom == nil {
+		return prometheus.DefaultGatherer
+	}
+	return r.prom
+}
+
+func (r *ireg) registerer() prometheus.Registerer {
+	if r.prom == nil {
+		return prometheus.DefaultRegisterer
+	}
+	return r.prom
+}
+
+// SetRelabelRules sets the relabel rules applied to every metric with the
+// label of a rule, before the rules of the metric itself.
+func (r *ireg) SetRelabelRules(rules []RelabelRule) error {
+	relabel, err := compileRelabel(rules)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.relabel = relabel
+	return nil
+}
+
+// SetAutoRegister sets how unknown metrics are registered automatically,
+// a nil config disables it.
+func (r *ireg) SetAutoRegister(config *AutoRegister) error {
+	auto, err := compileAutoRegister(config)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.auto = auto
+	return nil
+}
+
+func (r *ireg) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []string
+
+	for name, _ := range r.Handlers {
+		result = append(result, name)
+	}
+
+	return result
+}
+
+// Specs returns the specs of all registered metrics, sorted by name.
+func (r *ireg) Specs() []*MetricSpec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []*MetricSpec
+
+	for _, handler := range r.Handlers {
+		result = append(result, handler.Spec())
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// Series returns the label sets of every series which currently exists
+// for the named metric.
+func (r *ireg) Series(name string) ([]map[string]string, error) {
+	r.mu.Lock()
+	handler, ok := r.Handlers[name]
+	r.mu.Unlock()
+
+	if !ok {
+		return nil, NewMetricError(ReasonUnknownMetric, "Series: metric %s does not exist", name)
+	}
+
+	return collectSeries(handler.Collector(), handler.Spec().Labels)
+}
+
+func (r *ireg) Register(spec *MetricSpec) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.register(spec)
+}
+
+func (r *ireg) register(spec *MetricSpec) error {
+	if _, ok := r.Handlers[spec.Name]; ok {
+		return fmt.Errorf("Metric %s already exists", spec.Name)
+	}
+
+	if err := validateMetric(spec.Name); err != nil {
+		return err
+	}
+
+	if errs := relabelErrors(spec); len(errs) > 0 {
+		return errs[0]
+	}
+	relabel, _ := compileRelabel(spec.Relabel)
+
+	if errs := valueErrors(spec); len(errs) > 0 {
+		return errs[0]
+	}
+
+	validators, err := compileLabelRules(spec)
+	if err != nil {
+		return err
+	}
+
+	handler, err := buildHandler(spec)
+	if err != nil {
+		return err
+	}
+
+	if err := r.registerer().Register(handler.Collector()); err != nil {
+		return err
+	}
+
+	r.Handlers[spec.Name] = handler
+	r.specRelabel[spec.Name] = relabel
+	r.specLabels[spec.Name] = validators
+	if spec.File == AutoRegisteredFile {
+		r.autoCount++
+	}
+	return nil
+}
+
+func (r *ireg) Unregister(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	handler, ok := r.Handlers[name]
+	if !ok {
+		return fmt.Errorf("Unregister: metric %s does not exist", name)
+	}
+
+	if ok := r.registerer().Unregister(handler.Collector()); !ok {
+		return fmt.Errorf("Failed to unregister %s", name)
+	}
+
+	delete(r.Handlers, name)
+	delete(r.specRelabel, name)
+	delete(r.specLabels, name)
+	if handler.Spec().File == AutoRegisteredFile {
+		r.autoCount--
+	}
+
+	return nil
+}
+
+func (r *ireg) Handle(metric *Metric) error {
+	return r.HandleWithConstLabels(metric, nil)
+}
+
+// HandleWithConstLabels handles metric like Handle, with the values of
+// consts for the labels they name, such as those of a listener.
+func (r *ireg) HandleWithConstLabels(metric *Metric, consts map[string]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	handler, ok := r.Handlers[metric.Name]
+	if !ok {
+		var err error
+		if handler, err = r.autoRegister(metric, consts); err != nil {
+			return err
+		}
+	}
+
+	var warning error
+	if err := validateMethod(handler.Spec().Type, metric); err != nil {
+		if r.strict {
+			return err
+		}
+		warning = &Warning{err}
+	}
+
+	labels := handler.Spec().Labels
+	metric.LabelValues = withConstLabels(labels, metric.LabelValues, consts)
+	for _, rules := range [][]*relabeler{r.relabel, r.specRelabel[metric.Name]} {
+		values, ok := relabel(rules, labels, metric.LabelValues)
+		if !ok {
+			return ErrDropped
+		}
+		metric.LabelValues = values
+	}
+
+	values, err := validateLabelValues(r.specLabels[metric.Name], labels, metric.LabelValues)
+	if err != nil {
+		return err
+	}
+	metric.LabelValues = values
+
+	if err := checkValues(handler.Spec(), metric); err != nil {
+		return err
+	}
+
+	if err := handler.Handle(metric); err != nil {
+		return err
+	}
+
+	return warning
+}
+
+// autoRegister registers an unknown metric if its name matches an auto
+// register rule and the limit has not been reached. Metrics which are not
+// registered remain unknown, so that their names are not counted by
+// name.
+func (r *ireg) autoRegister(metric *Metric, consts map[string]string) (MetricHandler, error) {
+	spec := r.auto.spec(metric)
+	if spec == nil {
+		return nil, NewMetricError(ReasonUnknownMetric, "Handle: metric %s does not exist", metric.Name)
+	}
+	if r.autoCount >= r.auto.max {
+		return nil, NewMetricError(ReasonUnknownMetric, "Handle: metric %s does not exist, auto register limit of %d reached", metric.Name, r.auto.max)
+	}
+	// label names cannot be inferred, so don't use up the limit on
+	// metrics which would never be handled
+	values := withConstLabels(spec.Labels, metric.LabelValues, consts)
+	if len(values) != len(spec.Labels) {
+		return nil, NewMetricError(ReasonUnknownMetric, "Handle: metric %s does not exist, it has %d label values but auto register template has %d labels", metric.Name, len(values), len(spec.Labels))
+	}
+
+	if err := r.register(spec); err != nil {
+		return nil, NewMetricError(ReasonUnknownMetric, "Handle: metric %s does not exist, auto register failed: %s", metric.Name, err)
+	}
+	logger.Printf("Auto registered %s %s", spec.Type, spec.Name)
+
+	return r.Handlers[metric.Name], nil
+}
+
+func collectSeries(c prometheus.Collector, labels []string) ([]map[string]string, error) {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	var (
+		result = []map[string]string{}
+		err    error
+	)
+
+	for metric := range ch {
+		var out dto.Metric
+		if werr := metric.Write(&out); werr != nil {
+			err = werr
+			continue
+		}
+
+		series := make(map[string]string)
+		for _, lp := range out.Label {
+			series[lp.GetName()] = lp.GetValue()
+		}
+		result = append(result, series)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		for _, label := range labels {
+			if result[i][label] != result[j][label] {
+				return result[i][label] < result[j][label]
+			}
+		}
+		return false
+	})
+
+	return result, err
+}
+
+func buildHandler(spec *MetricSpec) (MetricHandler, error) {
+	ht, ok := lookupHandlerType(spec.Type)
+	if !ok {
+		return nil, fmt.Errorf("Unknown metric %s is unknown type %s", spec.Name, spec.Type)
+	}
+
+	return ht.factory(spec)
+}
+
+// specMaxAge returns the parsed max_age of a summary spec, or zero if it
+// is not set.
+func specMaxAge(spec *MetricSpec) (time.Duration, error) {
+	if spec.MaxAge == "" {
+		return 0, nil
+	}
+
+	maxAge, err := time.ParseDuration(spec.MaxAge)
+	if err != nil {
+		return 0, fmt.Errorf("Metric %s has invalid max_age: %s", spec.Name, err)
+	}
+	if maxAge <= 0 {
+		return 0, fmt.Errorf("Metric %s max_age must be positive", spec.Name)
+	}
+
+	return maxAge, nil
+}
+
+func validateMethod(metricType string, metric *Metric) error {
+	ht, _ := lookupHandlerType(metricType)
+	if !sliceContainsStr(ht.methods, metric.Method) {
+		return NewMetricError(ReasonBadMethod, "Invalid %s method %s for metric %s", metricType, metric.Method, metric.Name)
+	}
+
+	return nil
+}
+
+// ValidateMetric returns an error if metric would be rejected when
+// handled by a registry with spec registered.
+func ValidateMetric(spec *MetricSpec, metric *Metric) error {
+	if err := validateMethod(spec.Type, metric); err != nil {
+		return err
+	}
+	if len(metric.LabelValues) != len(spec.Labels) {
+		return NewMetricError(ReasonLabelMismatch, "Metric %s has %d label values, want %d", metric.Name, len(metric.LabelValues), len(spec.Labels))
+	}
+	// check a copy, since values are scaled in place
+	m := *metric
+	return checkValues(spec, &m)
+}
+
+func validateMetric(name string) error {
+	if !metricRe.MatchString(name) {
+		return fmt.Errorf("Metric name '%s' is not valid", name)
+	}
+
+	if strings.HasPrefix(name, "__") {
+		return fmt.Errorf("Metric name '%s' is reserved, names beginning with __ are for internal use", name)
+	}
+
+	return nil
+}
+
+// validateLabels returns the first problem with labels, reserved are
+// label names used by the metric type itself.
+func validateLabels(labels []string, reserved ...string) error {
+	if errs := labelErrors(labels, reserved...); len(errs) > 0 {
+		return errs[0]
+	}
+
+	return nil
+}
+
+func labelErrors(labels []string, reserved ...string) []error {
+	var errs []error
+
+	for i, label := range labels {
+		if !labelRe.MatchString(label) {
+			errs = append(errs, fmt.Errorf("Label name '%s' is not valid", label))
+		} else if strings.HasPrefix(label, "__") {
+			errs = append(errs, fmt.Errorf("Label name '%s' is reserved, names beginning with __ are for internal use", label))
+		} else if sliceContainsStr(reserved, label) {
+			errs = append(errs, fmt.Errorf("Label name '%s' is reserved for this metric type", label))
+		}
+
+		if sliceContainsStr(labels[:i], label) {
+			errs = append(errs, fmt.Errorf("Duplicate label found: %s", label))
+		}
+	}
+
+	return errs
+}
+
+func validateObjectives(objectives map[string]float64) (map[float64]float64, error) {
+	result := make(map[float64]float64)
+
+	for key, value := range objectives {
+		f, err := strconv.ParseFloat(key, 64)
+		if err != nil {
+			return result, err
+		}
+		if f < 0 || f > 1 {
+			return result, fmt.Errorf("Objective quantile %s must be between 0 and 1", key)
+		}
+		if value < 0 || value > 1 {
+			return result, fmt.Errorf("Objective error %g for quantile %s must be between 0 and 1", value, key)
+		}
+		if _, ok := result[f]; ok {
+			return result, fmt.Errorf("Duplicate objective quantile found: %s", key)
+		}
+		result[f] = value
+	}
+
+	return result, nil
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// actions of relabel rules
+const (
+	RelabelReplace   = "replace"
+	RelabelLowercase = "lowercase"
+	RelabelHashmod   = "hashmod"
+	RelabelDrop      = "drop"
+	RelabelKeep      = "keep"
+	RelabelOther     = "other"
+)
+
+// ErrDropped is returned by Registry.Handle when a metric is dropped by a
+// relabel rule.
+var ErrDropped = errors.New("metric dropped by relabel rule")
+
+type relabeler struct {
+	RelabelRule
+	re *regexp.Regexp
+}
+
+// compileRelabel validates and compiles rules. Regexes are anchored,
+// except for replace which replaces every match within the value.
+func compileRelabel(rules []RelabelRule) ([]*relabeler, error) {
+	var result []*relabeler
+
+	for i, rule := range rules {
+		r := &relabeler{RelabelRule: rule}
+		if r.Action == "" {
+			r.Action = RelabelReplace
+		}
+		if r.Label == "" {
+			return nil, fmt.Errorf("Relabel rule %d must have a label", i+1)
+		}
+
+		switch r.Action {
+		case RelabelReplace, RelabelDrop, RelabelKeep, RelabelOther:
+			if r.Regex == "" {
+				return nil, fmt.Errorf("Relabel rule %d must have a regex for action %s", i+1, r.Action)
+			}
+			expr := r.Regex
+			if r.Action != RelabelReplace {
+				expr = "^(?:" + expr + ")$"
+			}
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("Relabel rule %d has invalid regex '%s': %s", i+1, r.Regex, err)
+			}
+			r.re = re
+			if r.Action == RelabelOther && r.Replacement == "" {
+				r.Replacement = "other"
+			}
+		case RelabelLowercase:
+		case RelabelHashmod:
+			if r.Modulus == 0 {
+				return nil, fmt.Errorf("Relabel rule %d must have a positive modulus for action hashmod", i+1)
+			}
+		default:
+			return nil, fmt.Errorf("Relabel rule %d has unknown action '%s', must be one of replace, lowercase, hashmod, drop, keep or other", i+1, r.Action)
+		}
+
+		result = append(result, r)
+	}
+
+	return result, nil
+}
+
+// relabel applies rules to values, the label values of a metric with
+// labels. Rules for labels the metric does not have are skipped. It
+// returns the new label values, or false if the metric is dropped.
+func relabel(rules []*relabeler, labels, values []string) ([]string, bool) {
+	if len(rules) == 0 || len(labels) != len(values) {
+		return values, true
+	}
+
+	result := append([]string(nil), values...)
+	for _, r := range rules {
+		i := labelIndex(labels, r.Label)
+		if i < 0 {
+			continue
+		}
+
+		v := result[i]
+		switch r.Action {
+		case RelabelReplace:
+			result[i] = r.re.ReplaceAllString(v, r.Replacement)
+		case RelabelLowercase:
+			result[i] = strings.ToLower(v)
+		case RelabelHashmod:
+			sum := md5.Sum([]byte(v))
+			result[i] = strconv.FormatUint(binary.BigEndian.Uint64(sum[8:])%r.Modulus, 10)
+		case RelabelDrop:
+			if r.re.MatchString(v) {
+				return nil, false
+			}
+		case RelabelKeep:
+			if !r.re.MatchString(v) {
+				return nil, false
+			}
+		case RelabelOther:
+			if !r.re.MatchString(v) {
+				result[i] = r.Replacement
+			}
+		}
+	}
+
+	return result, true
+}
+
+// relabelErrors returns the problems with the relabel rules of spec.
+func relabelErrors(spec *MetricSpec) []error {
+	var errs []error
+
+	if _, err := compileRelabel(spec.Relabel); err != nil {
+		errs = append(errs, fmt.Errorf("Metric %s: %s", spec.Name, err))
+	}
+	for _, rule := range spec.Relabel {
+		if rule.Label != "" && labelIndex(spec.Labels, rule.Label) < 0 {
+			errs = append(errs, fmt.Errorf("Metric %s relabel rule for unknown label %s", spec.Name, rule.Label))
+		}
+	}
+
+	return errs
+}
+
+func labelIndex(labels []string, label string) int {
+	for i, l := range labels {
+		if l == label {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestRelabel(t *testing.T) {
+	rules, err := compileRelabel([]RelabelRule{
+		{Label: "path", Regex: `/\d+`, Replacement: "/:id"},
+		{Label: "method", Action: "lowercase"},
+		{Label: "method", Action: "other", Regex: "get|post"},
+		{Label: "status", Action: "drop", Regex: "1.."},
+		{Label: "status", Action: "keep", Regex: "[1-5].."},
+		{Label: "user", Action: "hashmod", Modulus: 8},
+		{Label: "missing", Action: "lowercase"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := []string{"path", "method", "status", "user"}
+	for _, tt := range []struct {
+		in  []string
+		out []string
+		ok  bool
+	}{
+		{[]string{"/users/123/posts/4", "GET", "200", "bob"}, []string{"/users/:id/posts/:id", "get", "200", "0"}, true},
+		{[]string{"/", "Post", "404", "bob"}, []string{"/", "post", "404", "0"}, true},
+		{[]string{"/", "PURGE", "500", "alice"}, []string{"/", "other", "500", "4"}, true},
+		{[]string{"/", "GET", "101", "bob"}, nil, false},
+		{[]string{"/", "GET", "nope", "bob"}, nil, false},
+	} {
+		in := append([]string(nil), tt.in...)
+		out, ok := relabel(rules, labels, in)
+		if ok != tt.ok || !sliceEqStr(out, tt.out) {
+			t.Errorf("relabel(%v) => %v %v, want %v %v", tt.in, out, ok, tt.out, tt.ok)
+		}
+		if !sliceEqStr(in, tt.in) {
+			t.Errorf("relabel(%v) changed its input to %v", tt.in, in)
+		}
+	}
+}
+
+func TestCompileRelabelFail(t *testing.T) {
+	for _, rule := range []RelabelRule{
+		{Regex: "a"},
+		{Label: "a"},
+		{Label: "a", Regex: "("},
+		{Label: "a", Action: "hashmod"},
+		{Label: "a", Action: "keep"},
+		{Label: "a", Action: "upper"},
+	} {
+		if _, err := compileRelabel([]RelabelRule{rule}); err == nil {
+			t.Errorf("compileRelabel(%+v) => nil, want error", rule)
+		}
+	}
+}
+
+func TestRegistryRelabel(t *testing.T) {
+	SetTestLogger()
+	registry := NewRegistry()
+
+	if err := registry.SetRelabelRules([]RelabelRule{{Label: "method", Action: "lowercase"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := &MetricSpec{Type: "counter", Name: "test_relabel_bad_total", Labels: []string{"path"},
+		Relabel: []RelabelRule{{Label: "nope", Action: "lowercase"}}}
+	if err := registry.Register(bad); err == nil {
+		t.Error("Expected relabel rule for unknown label to be rejected")
+	}
+
+	spec := &MetricSpec{Type: "counter", Name: "test_relabel_total", Help: "Relabel", Labels: []string{"method", "path"},
+		Relabel: []RelabelRule{
+			{Label: "path", Regex: `/\d+`, Replacement: "/:id"},
+			{Label: "path", Action: "drop", Regex: "/health"},
+		}}
+	if err := registry.Register(spec); err != nil {
+		t.Fatal(err)
+	}
+	defer registry.Unregister(spec.Name)
+
+	for _, values := range [][]string{{"GET", "/users/1"}, {"get", "/users/2"}} {
+		if err := registry.Handle(&Metric{Name: spec.Name, Method: "inc", LabelValues: values}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := registry.Handle(&Metric{Name: spec.Name, Method: "inc", LabelValues: []string{"GET", "/health"}}); err != ErrDropped {
+		t.Errorf("Expected metric to be dropped, but got %v", err)
+	}
+
+	series, err := registry.Series(spec.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 1 || series[0]["method"] != "get" || series[0]["path"] != "/users/:id" {
+		t.Errorf("Expected a single relabeled series, but got %v", series)
+	}
+}
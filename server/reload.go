@@ -0,0 +1,205 @@
+package server
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// outcomes of a spec when metric specs are reloaded
+const (
+	OutcomeRegistered   = "registered"
+	OutcomeUnregistered = "unregistered"
+	OutcomeRejected     = "rejected"
+	OutcomeUnchanged    = "unchanged"
+)
+
+var (
+	reloadMu sync.Mutex
+
+	configLastReloadSuccessful = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pmp_config_last_reload_successful",
+			Help: "Whether the last metrics configuration reload attempt was successful without errors",
+		},
+		[]string{"tenant"},
+	)
+
+	configLastReloadSuccessTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pmp_config_last_reload_success_timestamp_seconds",
+			Help: "Timestamp of the last successful metrics configuration reload",
+		},
+		[]string{"tenant"},
+	)
+
+	configHash = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pmp_config_hash",
+			Help: "Hash of the currently loaded metrics configuration",
+		},
+		[]string{"tenant"},
+	)
+
+	configLastReloadMetrics = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pmp_config_last_reload_metrics",
+			Help: "Number of metrics registered, unregistered or rejected by the last successful reload",
+		},
+		[]string{"tenant", "outcome"},
+	)
+)
+
+// ReloadOutcome describes what happened to a single metric spec when the
+// metric specs were reloaded.
+type ReloadOutcome struct {
+	Name    string `json:"name,omitempty"`
+	File    string `json:"file,omitempty"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ReloadSpecs loads the metric specs from file, registers metrics which
+// are new and unregisters metrics which are no longer present. If the
+// specs cannot be loaded the registry is left untouched, likewise for
+// the metrics of an individual file when file is a directory or glob.
+func ReloadSpecs(registry Registry, file string) ([]ReloadOutcome, error) {
+	outcomes, _, err := reloadSpecs(logger, "", registry, file)
+	return outcomes, err
+}
+
+// reloadSpecs reloads the metric specs of tenant like ReloadSpecs, the
+// metrics of Options.Metrics have an empty tenant. It also returns whether
+// the reload was successful, which it is not if the specs failed to load
+// or had errors.
+func reloadSpecs(logger *log.Logger, tenant string, registry Registry, file string) ([]ReloadOutcome, bool, error) {
+	// reloads may be triggered by a signal or the admin api
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	// note beginning names of metrics
+	names := registry.Names()
+
+	set, err := LoadSpecs(file)
+	if err != nil {
+		configLastReloadSuccessful.WithLabelValues(tenant).Set(0)
+		return nil, false, err
+	}
+
+	var outcomes []ReloadOutcome
+	counts := make(map[string]int)
+
+	for _, serr := range set.Errors {
+		logger.Println(serr)
+		outcomes = append(outcomes, ReloadOutcome{
+			Name:    serr.Name,
+			File:    serr.File,
+			Outcome: OutcomeRejected,
+			Error:   serr.Err.Error(),
+		})
+		if serr.Name != "" {
+			counts[OutcomeRejected]++
+		}
+	}
+
+	// auto registered metrics are kept, like other existing metrics
+	// they are not changed if they are now defined in a file
+	var auto []string
+	for _, spec := range registry.Specs() {
+		if spec.File == AutoRegisteredFile {
+			auto = append(auto, spec.Name)
+		}
+	}
+
+	registered := make(map[string]*MetricSpec)
+	for _, spec := range registry.Specs() {
+		registered[spec.Name] = spec
+	}
+
+	newNames := []string{}
+	for _, spec := range set.Specs {
+		newNames = append(newNames, spec.Name)
+		outcome := ReloadOutcome{Name: spec.Name, File: spec.File}
+		if current, ok := registered[spec.Name]; ok {
+			if specsEqual(current, spec) {
+				outcome.Outcome = OutcomeUnchanged
+			} else {
+				err := fmt.Errorf("Metric %s already exists, existing metrics cannot be changed", spec.Name)
+				logger.Println(err)
+				outcome.Outcome = OutcomeRejected
+				outcome.Error = err.Error()
+			}
+		} else if err := registry.Register(spec); err != nil {
+			logger.Println(err)
+			outcome.Outcome = OutcomeRejected
+			outcome.Error = err.Error()
+		} else {
+			logger.Printf("Registered %s", spec.Name)
+			outcome.Outcome = OutcomeRegistered
+		}
+		counts[outcome.Outcome]++
+		outcomes = append(outcomes, outcome)
+	}
+
+	// keep metrics loaded from files which now fail to load
+	failed := set.FailedFiles()
+	for _, spec := range registry.Specs() {
+		if sliceContainsStr(failed, spec.File) && !sliceContainsStr(newNames, spec.Name) {
+			newNames = append(newNames, spec.Name)
+			outcomes = append(outcomes, ReloadOutcome{Name: spec.Name, File: spec.File, Outcome: OutcomeUnchanged})
+		}
+	}
+
+	for _, name := range auto {
+		if !sliceContainsStr(newNames, name) {
+			newNames = append(newNames, name)
+			outcomes = append(outcomes, ReloadOutcome{Name: name, File: AutoRegisteredFile, Outcome: OutcomeUnchanged})
+		}
+	}
+
+	// get names of metrics no longer present and unregister them
+	unreg := sliceSubStr(names, newNames)
+	for _, name := range unreg {
+		outcome := ReloadOutcome{Name: name, Outcome: OutcomeUnregistered}
+		if err := registry.Unregister(name); err != nil {
+			logger.Println(err)
+			outcome.Error = err.Error()
+		} else {
+			logger.Printf("Unregistered %s", name)
+			ForgetSamples(tenant, name)
+			counts[OutcomeUnregistered]++
+		}
+		outcomes = append(outcomes, outcome)
+	}
+
+	for _, o := range []string{OutcomeRegistered, OutcomeUnregistered, OutcomeRejected} {
+		configLastReloadMetrics.WithLabelValues(tenant, o).Set(float64(counts[o]))
+	}
+	configHash.WithLabelValues(tenant).Set(hashValue(set.Hash))
+	if len(set.Errors) > 0 {
+		configLastReloadSuccessful.WithLabelValues(tenant).Set(0)
+	} else {
+		configLastReloadSuccessful.WithLabelValues(tenant).Set(1)
+		configLastReloadSuccessTimestamp.WithLabelValues(tenant).Set(float64(time.Now().Unix()))
+	}
+
+	return outcomes, len(set.Errors) == 0, nil
+}
+
+// hashValue converts the leading 48 bits of a hex encoded hash into a
+// float64 without loss of precision, so it can be used as a gauge value.
+func hashValue(hash string) float64 {
+	b, err := hex.DecodeString(hash)
+	if err != nil || len(b) < 6 {
+		return 0
+	}
+
+	buf := make([]byte, 8)
+	copy(buf[2:], b[:6])
+	return float64(binary.BigEndian.Uint64(buf))
+}
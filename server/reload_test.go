@@ -0,0 +1,193 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestReloadSpecs(t *testing.T) {
+	SetTestLogger()
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	registry := NewRegistry()
+
+	file := writeTestFile(t, dir, "metrics.json", `[
+		{"type": "counter", "name": "test_reload_one", "help": "One"},
+		{"type": "counter", "name": "test_reload_two", "help": "Two"}
+	]`)
+	outcomes, err := ReloadSpecs(registry, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range outcomes {
+		if o.Outcome != OutcomeRegistered {
+			t.Errorf("Expected %s to be registered, but was %s", o.Name, o.Outcome)
+		}
+	}
+
+	writeTestFile(t, dir, "metrics.json", `[
+		{"type": "counter", "name": "test_reload_two", "help": "Two"},
+		{"type": "nope", "name": "test_reload_three", "help": "Three"}
+	]`)
+	outcomes, err = ReloadSpecs(registry, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"test_reload_one":   OutcomeUnregistered,
+		"test_reload_two":   OutcomeUnchanged,
+		"test_reload_three": OutcomeRejected,
+	}
+	if len(outcomes) != len(expected) {
+		t.Fatalf("Expected %d outcomes, but got %+v", len(expected), outcomes)
+	}
+	for _, o := range outcomes {
+		if o.Outcome != expected[o.Name] {
+			t.Errorf("Expected %s to be %s, but was %s", o.Name, expected[o.Name], o.Outcome)
+		}
+	}
+
+	writeTestFile(t, dir, "metrics.json", `[
+		{"type": "counter", "name": "test_reload_two", "help": "Two", "labels": ["status"]}
+	]`)
+	outcomes, err = ReloadSpecs(registry, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outcomes) != 1 || outcomes[0].Outcome != OutcomeRejected || outcomes[0].Error == "" {
+		t.Errorf("Expected changed metric to be rejected, but got %+v", outcomes)
+	}
+
+	writeTestFile(t, dir, "metrics.json", `[{"type": "counter",`)
+	if _, err := ReloadSpecs(registry, file); err == nil {
+		t.Fatal("Expected broken metrics file to throw error, but did not")
+	}
+	if names := registry.Names(); !sliceEqStr(names, []string{"test_reload_two"}) {
+		t.Errorf("Expected broken metrics file not to change registry, but got %v", names)
+	}
+}
+
+func TestReloadSpecsFailedFile(t *testing.T) {
+	SetTestLogger()
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestFile(t, dir, "one.json", `[{"type": "counter", "name": "test_reload_failed_total", "help": "Total"}]`)
+	writeTestFile(t, dir, "two.json", `[{"type": "counter",`)
+
+	registry := NewRegistry()
+	defer registry.Unregister("test_reload_failed_total")
+	s := NewServer(Options{Registry: registry})
+	if _, err := s.reload("", registry, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// the reload check agrees with the last reload metric
+	var m dto.Metric
+	if err := configLastReloadSuccessful.WithLabelValues("").Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.GetGauge().GetValue() != 0 {
+		t.Errorf("Expected last reload to be unsuccessful, but got %v", m.GetGauge().GetValue())
+	}
+	if !sliceContainsStr(s.readiness.Failing(), CheckReload) {
+		t.Errorf("Expected reload check to fail while a file fails to load")
+	}
+
+	writeTestFile(t, dir, "two.json", `[]`)
+	if _, err := s.reload("", registry, dir); err != nil {
+		t.Fatal(err)
+	}
+	if sliceContainsStr(s.readiness.Failing(), CheckReload) {
+		t.Errorf("Expected reload check to pass once every file loads")
+	}
+}
+
+func TestReloadSpecsTenants(t *testing.T) {
+	SetTestLogger()
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := writeTestFile(t, dir, "metrics.json", `[{"type": "counter", "name": "test_reload_tenant_total", "help": "Total"}]`)
+	broken := writeTestFile(t, dir, "broken.json", `[{"type": "counter",`)
+
+	registry := NewRegistry()
+	defer registry.Unregister("test_reload_tenant_total")
+	tenantRegistry := NewTenantRegistry(false)
+	s := NewServer(Options{Registry: registry})
+
+	if _, err := s.reload("test_reload", tenantRegistry, broken); err == nil {
+		t.Fatal("Expected broken metrics file to throw error, but did not")
+	}
+	if _, err := s.reload("", registry, file); err != nil {
+		t.Fatal(err)
+	}
+
+	// a successful reload of another tenant does not hide the failure
+	if !sliceContainsStr(s.readiness.Failing(), CheckReload) {
+		t.Errorf("Expected reload check to fail while a tenant fails to load")
+	}
+
+	// nor does the successful reload of another server
+	other := NewServer(Options{})
+	if _, err := other.reload("", NewTenantRegistry(false), file); err != nil {
+		t.Fatal(err)
+	}
+	if sliceContainsStr(other.readiness.Failing(), CheckReload) || !sliceContainsStr(s.readiness.Failing(), CheckReload) {
+		t.Errorf("Expected reload checks of servers to be independent")
+	}
+	for tenant, value := range map[string]float64{"": 1, "test_reload": 0} {
+		var m dto.Metric
+		if err := configLastReloadSuccessful.WithLabelValues(tenant).Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		if m.GetGauge().GetValue() != value {
+			t.Errorf("Expected last reload of tenant '%s' to be %v, but got %v", tenant, value, m.GetGauge().GetValue())
+		}
+	}
+
+	if _, err := s.reload("test_reload", tenantRegistry, file); err != nil {
+		t.Fatal(err)
+	}
+	if sliceContainsStr(s.readiness.Failing(), CheckReload) {
+		t.Errorf("Expected reload check to pass once every tenant loads")
+	}
+}
+
+func TestHashValue(t *testing.T) {
+	for _, tt := range []struct {
+		hash  string
+		value float64
+	}{
+		{"", 0},
+		{"nope", 0},
+		{"000000000001ff", 1},
+		{"ffffffffffff", 281474976710655},
+	} {
+		if value := hashValue(tt.hash); value != tt.value {
+			t.Errorf("hashValue(%s) => %f, want %f", tt.hash, value, tt.value)
+		}
+	}
+}
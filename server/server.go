@@ -0,0 +1,566 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ErrRunning is returned by Run when the server is already running.
+var ErrRunning = errors.New("server is already running")
+
+// how long a client may take to send its data and close the connection
+const readTimeout = 10 * time.Second
+
+type Options struct {
+	// path of the unix socket to listen on for incoming metrics
+	Socket string
+	// path of the file, directory or glob of files which contain metric
+	// definitions
+	Metrics string
+	// address to serve prometheus metrics on, nothing is served if empty
+	Addr string
+	// path to serve prometheus metrics on, defaults to /metrics
+	Path string
+	// address to serve the admin api on, it is not served if empty
+	AdminAddr string
+	// registry metrics are registered in, defaults to NewRegistry()
+	Registry Registry
+	// reload the metric definitions when their files change
+	Watch bool
+	// number of goroutines parsing incoming data, defaults to the number
+	// of cpus
+	Workers int
+	// stages each metric is processed by before it is handled
+	Stages Chain
+	// additional sockets to listen on, each with its own namespace
+	Listeners []Listener
+	// metrics exposed separately from those of Registry, on Path followed
+	// by the name of the tenant
+	Tenants []Tenant
+	// logger of the server, defaults to the logger set by SetLogger
+	Logger *log.Logger
+}
+
+// Server listens on a unix socket for metrics and exposes them to
+// prometheus. Each server has its own readiness and reload state, so
+// several may run in one process as long as their registries do not
+// register the same metric names with the same prometheus registry.
+type Server struct {
+	opts      Options
+	registry  Registry
+	tenants   map[string]Registry
+	reloadCh  chan struct{}
+	readiness *checks
+
+	// tenants whose last reload was not successful, the metrics of
+	// Options.Metrics have an empty tenant
+	reloadMu     sync.Mutex
+	reloadFailed map[string]bool
+
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	servers []*http.Server
+}
+
+func NewServer(opts Options) *Server {
+	if opts.Path == "" {
+		opts.Path = "/metrics"
+	}
+	if opts.Registry == nil {
+		opts.Registry = NewRegistry()
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+
+	tenants := make(map[string]Registry)
+	opts.Tenants = append([]Tenant(nil), opts.Tenants...)
+	for i := range opts.Tenants {
+		if opts.Tenants[i].Registry == nil {
+			opts.Tenants[i].Registry = NewTenantRegistry(false)
+		}
+		tenants[opts.Tenants[i].Name] = opts.Tenants[i].Registry
+	}
+
+	return &Server{
+		opts:         opts,
+		registry:     opts.Registry,
+		tenants:      tenants,
+		reloadCh:     make(chan struct{}, 1),
+		readiness:    newChecks(CheckSocket, CheckSpecs, CheckProcessor, CheckReload),
+		reloadFailed: make(map[string]bool),
+	}
+}
+
+// logger returns Options.Logger, or the logger set by SetLogger, which
+// may be replaced while the server runs.
+func (s *Server) logger() *log.Logger {
+	if s.opts.Logger != nil {
+		return s.opts.Logger
+	}
+	return logger
+}
+
+func (s *Server) Registry() Registry {
+	return s.registry
+}
+
+// TenantRegistry returns the registry of the named tenant, or nil if
+// there is no such tenant.
+func (s *Server) TenantRegistry(name string) Registry {
+	return s.tenants[name]
+}
+
+// Handler returns the http handler served on Addr.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle(s.opts.Path, promhttp.HandlerFor(s.registry.Gatherer(), promhttp.HandlerOpts{
+		ErrorLog: s.logger(),
+	}))
+	for _, t := range s.opts.Tenants {
+		mux.Handle(strings.TrimSuffix(s.opts.Path, "/")+"/"+t.Name, promhttp.HandlerFor(t.Registry.Gatherer(), promhttp.HandlerOpts{
+			ErrorLog: s.logger(),
+		}))
+	}
+	mux.HandleFunc("/debug/unknown_metrics", UnknownMetricsHandler)
+	mux.HandleFunc("/healthz", HealthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler(s.readiness))
+
+	return mux
+}
+
+// Reload reloads the metric definitions once the metrics being processed
+// are handled. Reloads requested while one is pending are merged.
+func (s *Server) Reload() {
+	select {
+	case s.reloadCh <- struct{}{}:
+	default:
+	}
+}
+
+// Run listens on the sockets and http addresses and processes metrics
+// until ctx is done or Shutdown is called. The sockets are removed when
+// it returns.
+func (s *Server) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.mu.Unlock()
+		return ErrRunning
+	}
+	done := make(chan struct{})
+	s.cancel, s.done = cancel, done
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.cancel, s.done, s.servers = nil, nil, nil
+		s.mu.Unlock()
+		close(done)
+	}()
+
+	if err := validateTenants(s.opts.Tenants); err != nil {
+		return err
+	}
+	listeners, err := compileListeners(s.opts.Socket, s.opts.Listeners, tenantNames(s.opts.Tenants))
+	if err != nil {
+		return err
+	}
+
+	var lns []net.Listener
+	defer func() {
+		for _, ln := range lns {
+			ln.Close()
+		}
+	}()
+	for _, l := range listeners {
+		ln, err := net.Listen("unix", l.Socket)
+		if err != nil {
+			return err
+		}
+		lns = append(lns, ln)
+
+		if err := os.Chmod(l.Socket, 0777); err != nil {
+			return err
+		}
+	}
+	s.readiness.Set(CheckSocket, true)
+	defer s.readiness.Set(CheckSocket, false)
+
+	if s.opts.Watch {
+		files := []string{s.opts.Metrics}
+		for _, t := range s.opts.Tenants {
+			files = append(files, t.Metrics)
+		}
+		for _, file := range files {
+			watcher, err := WatchSpecs(file, watchDelay, func() {
+				s.logger().Println("Metrics file change detected")
+				s.Reload()
+			})
+			if err != nil {
+				return err
+			}
+			defer watcher.Close()
+		}
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errCh    = make(chan error, 3)
+		dataCh   = make(chan socketData)
+		metricCh = make(chan socketMetric)
+	)
+
+	for i := 0; i < s.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.parseData(ctx, dataCh, metricCh)
+		}()
+	}
+
+	for i := range lns {
+		wg.Add(1)
+		go func(ln net.Listener, l *listener) {
+			defer wg.Done()
+			s.readData(ctx, ln, l, dataCh)
+		}(lns[i], listeners[i])
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			// recover a panic here to make sure the socket gets cleaned up
+			if r := recover(); r != nil {
+				errCh <- fmt.Errorf("Recovered panic: %s", r)
+			}
+		}()
+		s.process(ctx, metricCh)
+	}()
+
+	if s.opts.Addr != "" {
+		s.serve(s.opts.Addr, s.Handler(), errCh)
+	}
+	if s.opts.AdminAddr != "" {
+		s.serve(s.opts.AdminAddr, s.AdminHandler(), errCh)
+	}
+
+	select {
+	case <-ctx.Done():
+	case err = <-errCh:
+	}
+
+	cancel()
+	for _, ln := range lns {
+		ln.Close()
+	}
+	s.mu.Lock()
+	for _, srv := range s.servers {
+		srv.Close()
+	}
+	s.mu.Unlock()
+	wg.Wait()
+
+	// free the metric names for the next server
+	for _, name := range s.registry.Names() {
+		s.registry.Unregister(name)
+	}
+	for _, t := range s.opts.Tenants {
+		for _, name := range t.Registry.Names() {
+			t.Registry.Unregister(name)
+		}
+	}
+
+	return err
+}
+
+// Shutdown gracefully stops the http servers and the server started by
+// Run, and waits for Run to return or ctx to be done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	cancel, done, servers := s.cancel, s.done, s.servers
+	s.mu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+
+	var err error
+	for _, srv := range servers {
+		if serr := srv.Shutdown(ctx); serr != nil && err == nil {
+			err = serr
+		}
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) serve(addr string, handler http.Handler, errCh chan<- error) {
+	srv := &http.Server{Addr: addr, Handler: handler, ErrorLog: s.logger()}
+
+	s.mu.Lock()
+	s.servers = append(s.servers, srv)
+	s.mu.Unlock()
+
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			errCh <- fmt.Errorf("http %s: %s", addr, err)
+		}
+	}()
+}
+
+// process loads the metric definitions and handles metrics until ctx is
+// done, reloading the definitions when requested.
+func (s *Server) process(ctx context.Context, metricCh <-chan socketMetric) {
+	for {
+		s.logger().Println("Loading metric configuration")
+		s.reloadAll()
+
+		if !s.processData(ctx, metricCh) {
+			return
+		}
+	}
+}
+
+// reloadAll reloads the metric specs of the default tenant and of every
+// tenant. Only the metrics of tenants whose specs load are registered or
+// unregistered.
+func (s *Server) reloadAll() reloadResponse {
+	var result reloadResponse
+
+	outcomes, err := s.reload("", s.registry, s.opts.Metrics)
+	result.Outcomes = outcomes
+	if err != nil {
+		s.logger().Printf("Error loading configuration: %s", err)
+		result.Error = err.Error()
+	}
+
+	for _, t := range s.opts.Tenants {
+		var tenant reloadResponse
+		outcomes, err := s.reload(t.Name, t.Registry, t.Metrics)
+		tenant.Outcomes = outcomes
+		if err != nil {
+			s.logger().Printf("Error loading configuration of tenant %s: %s", t.Name, err)
+			tenant.Error = err.Error()
+		}
+		if result.Tenants == nil {
+			result.Tenants = make(map[string]reloadResponse)
+		}
+		result.Tenants[t.Name] = tenant
+	}
+
+	return result
+}
+
+// reload reloads the metric specs of tenant from file. The reload check
+// only passes if the last reload of every tenant was successful.
+func (s *Server) reload(tenant string, registry Registry, file string) ([]ReloadOutcome, error) {
+	outcomes, ok, err := reloadSpecs(s.logger(), tenant, registry, file)
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	if ok {
+		delete(s.reloadFailed, tenant)
+	} else {
+		s.reloadFailed[tenant] = true
+	}
+	if err == nil {
+		s.readiness.Set(CheckSpecs, true)
+	}
+	s.readiness.Set(CheckReload, len(s.reloadFailed) == 0)
+
+	return outcomes, err
+}
+
+// socketData is the data of a connection to the socket of listener.
+type socketData struct {
+	listener *listener
+	data     []byte
+}
+
+// socketMetric is a metric read from the socket of listener.
+type socketMetric struct {
+	Metric
+	listener *listener
+}
+
+func (s *Server) readData(ctx context.Context, ln net.Listener, l *listener, dataCh chan<- socketData) {
+	s.logger().Printf("Starting listening on socket %s", ln.Addr())
+	defer s.logger().Printf("Ending listening on socket %s", ln.Addr())
+
+	for {
+		// accept a connection
+		c, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			CountError(&MetricError{ReasonReadError, err})
+			s.logger().Printf("ERROR (DataReader): %s", err)
+			continue
+		}
+
+		// idle clients must not block other connections or shutdown
+		c.SetReadDeadline(time.Now().Add(readTimeout))
+		closed := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				c.Close()
+			case <-closed:
+			}
+		}()
+
+		var buf bytes.Buffer
+		_, err = io.Copy(&buf, c)
+		close(closed)
+		c.Close()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			CountError(&MetricError{ReasonReadError, err})
+			s.logger().Printf("ERROR (DataReader): %s", err)
+			continue
+		}
+
+		select {
+		case dataCh <- socketData{l, buf.Bytes()}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Server) parseData(ctx context.Context, dataCh <-chan socketData, metricCh chan<- socketMetric) {
+	for {
+		var data socketData
+		select {
+		case data = <-dataCh:
+		case <-ctx.Done():
+			return
+		}
+
+		var metrics []Metric
+		start := time.Now()
+		err := json.Unmarshal(data.data, &metrics)
+		parseDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			CountError(&MetricError{ReasonParseError, err})
+			s.logger().Printf("ERROR (DataParser): %s", err)
+			continue
+		}
+
+		for i := 0; i < len(metrics); i++ {
+			if err := data.listener.apply(&metrics[i]); err != nil {
+				CountError(err)
+				s.logger().Printf("ERROR (DataParser): %s", err)
+				continue
+			}
+			select {
+			case metricCh <- socketMetric{metrics[i], data.listener}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// processData handles metrics until a reload is requested, when it
+// returns true, or ctx is done.
+func (s *Server) processData(ctx context.Context, metricCh <-chan socketMetric) bool {
+	s.logger().Println("Starting processing data")
+	s.readiness.Set(CheckProcessor, true)
+	defer s.readiness.Set(CheckProcessor, false)
+
+	for {
+		select {
+		case metric := <-metricCh:
+			consts := metric.listener.constLabels()
+			if len(s.opts.Stages) == 0 {
+				s.handle(metric.Metric, consts)
+				continue
+			}
+
+			metrics, err := s.opts.Stages.Process(metric.Metric)
+			if err != nil {
+				CountError(err)
+				s.logger().Printf("ERROR (DataProcessor): %s %+v", err, metric.Metric)
+				continue
+			}
+			if len(metrics) == 0 {
+				CountMetric("dropped")
+				continue
+			}
+			for _, m := range metrics {
+				s.handle(m, consts)
+			}
+		case <-s.reloadCh:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// handle handles metric with the values of consts for the labels they
+// name.
+func (s *Server) handle(metric Metric, consts map[string]string) {
+	registry := s.registry
+	if metric.Tenant != "" {
+		var ok bool
+		if registry, ok = s.tenants[metric.Tenant]; !ok {
+			err := NewMetricError(ReasonUnknownTenant, "Tenant %s does not exist", metric.Tenant)
+			CountError(err)
+			s.logger().Printf("ERROR (DataProcessor): %s %+v", err, metric)
+			return
+		}
+	}
+
+	start := time.Now()
+	err := registry.HandleWithConstLabels(&metric, consts)
+	handleDuration.Observe(time.Since(start).Seconds())
+	if err == ErrDropped {
+		CountMetric("dropped")
+		return
+	}
+	CountSample(metric.Tenant, metric.Name, err)
+	if IsWarning(err) {
+		CountWarning(err)
+		s.logger().Printf("WARNING (DataProcessor): %s %+v", err, metric)
+		return
+	}
+	if err != nil {
+		CountError(err)
+		s.logger().Printf("ERROR (DataProcessor): %s %+v", err, metric)
+		return
+	}
+	CountMetric("ok")
+}
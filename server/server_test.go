@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atongen/prom_multi_proc/client"
+)
+
+// eventually fails t unless fn returns true within a few seconds.
+func eventually(t *testing.T, msg string, fn func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if fn() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal(msg)
+}
+
+func TestServerRun(t *testing.T) {
+	SetTestLogger()
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "test.sock")
+	file := writeTestFile(t, dir, "metrics.json", `[
+		{"type": "counter", "name": "test_server_total", "help": "Server", "labels": ["status"]}
+	]`)
+
+	s := NewServer(Options{Socket: socket, Metrics: file, Workers: 2})
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Run(context.Background())
+	}()
+
+	eventually(t, "Expected metrics to be registered", func() bool {
+		return sliceEqStr(s.Registry().Names(), []string{"test_server_total"})
+	})
+
+	c := client.New(client.Options{Socket: socket})
+	c.Inc("test_server_total", "ok")
+	c.Add("test_server_total", 2, "ok")
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "Expected metrics to be handled", func() bool {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		return strings.Contains(rec.Body.String(), `test_server_total{status="ok"} 3`)
+	})
+
+	// the admin api is only served on AdminAddr
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/-/reload", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected admin api not to be served on Addr, but got status %d", rec.Code)
+	}
+
+	writeTestFile(t, dir, "metrics.json", `[
+		{"type": "gauge", "name": "test_server_gauge", "help": "Server"}
+	]`)
+	s.Reload()
+	eventually(t, "Expected metrics to be reloaded", func() bool {
+		return sliceEqStr(s.Registry().Names(), []string{"test_server_gauge"})
+	})
+
+	if err := s.Run(context.Background()); err != ErrRunning {
+		t.Errorf("Run while running => %v, want %v", err, ErrRunning)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("Expected socket to be removed, but got %v", err)
+	}
+	if names := s.Registry().Names(); len(names) != 0 {
+		t.Errorf("Expected metrics to be unregistered, but got %v", names)
+	}
+}
+
+func TestServerRunCancelWithOpenConnection(t *testing.T) {
+	SetTestLogger()
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "test.sock")
+	file := writeTestFile(t, dir, "metrics.json", `[]`)
+	s := NewServer(Options{Socket: socket, Metrics: file})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Run(ctx)
+	}()
+
+	var conn net.Conn
+	eventually(t, "Expected socket to be listening", func() bool {
+		conn, err = net.Dial("unix", socket)
+		return err == nil
+	})
+	defer conn.Close()
+
+	// let the idle connection be accepted before cancelling
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected Run to return after cancel with an open connection")
+	}
+}
+
+func TestServerLogger(t *testing.T) {
+	SetTestLogger()
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := writeTestFile(t, dir, "metrics.json", `[{"type": "counter", "name": "test_logger_total", "help": "Total"}]`)
+
+	var out bytes.Buffer
+	registry := NewTenantRegistry(false)
+	s := NewServer(Options{Registry: registry, Metrics: file, Logger: log.New(&out, "", 0)})
+	if _, err := s.reload("", registry, file); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Registered test_logger_total") {
+		t.Errorf("Expected server to log to its own logger, but got %q", out.String())
+	}
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// SpecSet is the result of loading metric specs from one or more files.
+type SpecSet struct {
+	Specs []*MetricSpec
+
+	// hex encoded sha256 hash of the contents of all files
+	Hash string
+
+	// files which failed to load, and specs which conflict with a spec
+	// of the same name from another file
+	Errors []*SpecError
+}
+
+type SpecError struct {
+	File string
+	Name string
+	Err  error
+}
+
+func (e *SpecError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("%s: %s", e.File, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.File, e.Name, e.Err)
+}
+
+// FailedFiles returns the files which could not be loaded.
+func (s *SpecSet) FailedFiles() []string {
+	var result []string
+
+	for _, err := range s.Errors {
+		if err.Name == "" {
+			result = append(result, err.File)
+		}
+	}
+
+	return result
+}
+
+// LoadSpecs reads the metric specs from path, which may be a single file,
+// a directory or a glob. When path is a single file any error loading it
+// is returned. Otherwise a file which fails to load is reported in the
+// SpecSet's Errors and the remaining files are still loaded.
+func LoadSpecs(path string) (*SpecSet, error) {
+	files, multi, err := specFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &SpecSet{}
+	h := sha256.New()
+	defined := make(map[string]*MetricSpec)
+
+	for _, file := range files {
+		specs, err := loadSpecFile(file, h)
+		if err != nil {
+			if !multi {
+				return nil, err
+			}
+			set.Errors = append(set.Errors, &SpecError{File: file, Err: err})
+			continue
+		}
+
+		for _, spec := range specs {
+			spec.File = file
+			if other, ok := defined[spec.Name]; ok {
+				if !specsEqual(spec, other) {
+					set.Errors = append(set.Errors, &SpecError{
+						File: file,
+						Name: spec.Name,
+						Err:  fmt.Errorf("conflicts with definition in %s", other.File),
+					})
+				}
+				continue
+			}
+			defined[spec.Name] = spec
+			set.Specs = append(set.Specs, spec)
+		}
+	}
+
+	if multi && len(set.Errors) > 0 && len(set.FailedFiles()) == len(files) {
+		return nil, fmt.Errorf("All metrics files in %s failed to load", path)
+	}
+
+	set.Hash = hex.EncodeToString(h.Sum(nil))
+	return set, nil
+}
+
+func loadSpecFile(file string, h io.Writer) ([]*MetricSpec, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	decode, ok := specDecoders[filepath.Ext(file)]
+	if !ok {
+		decode = decodeJSONSpecs
+	}
+
+	specs, err := decode(b)
+	if err != nil {
+		return nil, err
+	}
+
+	io.WriteString(h, file)
+	h.Write(b)
+
+	return specs, nil
+}
+
+// specFiles returns the files path refers to, and whether path is a
+// directory or glob rather than a single file.
+func specFiles(path string) ([]string, bool, error) {
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && info.IsDir():
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, true, err
+		}
+		var files []string
+		for _, entry := range entries {
+			if isSpecFile(entry.Name()) && !entry.IsDir() {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+		if len(files) == 0 {
+			return nil, true, fmt.Errorf("No metrics files found in %s", path)
+		}
+		return files, true, nil
+	case err == nil:
+		return []string{path}, false, nil
+	case isGlob(path):
+		files, err := filepath.Glob(path)
+		if err != nil {
+			return nil, true, err
+		}
+		if len(files) == 0 {
+			return nil, true, fmt.Errorf("No metrics files match %s", path)
+		}
+		sort.Strings(files)
+		return files, true, nil
+	default:
+		return nil, false, err
+	}
+}
+
+// isSpecFile reports whether name should be loaded from a directory,
+// hidden files such as editor swap files are skipped.
+func isSpecFile(name string) bool {
+	_, ok := specDecoders[filepath.Ext(name)]
+	return ok && !strings.HasPrefix(name, ".")
+}
+
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
+
+// specsEqual compares the definitions of two specs, ignoring which file
+// they were loaded from.
+func specsEqual(a, b *MetricSpec) bool {
+	x, y := *a, *b
+	x.File, y.File = "", ""
+	return reflect.DeepEqual(x, y)
+}
+
+func ReadSpecs(r io.Reader) ([]*MetricSpec, error) {
+	var result []*MetricSpec
+
+	jsonBlob, err := ioutil.ReadAll(r)
+	if err != nil {
+		return result, err
+	}
+
+	return decodeJSONSpecs(jsonBlob)
+}
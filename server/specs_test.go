@@ -0,0 +1,102 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSpecsDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestFile(t, dir, "a.json", `[
+		{"type": "counter", "name": "test_dir_one", "help": "One"},
+		{"type": "counter", "name": "test_dir_two", "help": "Two"}
+	]`)
+	writeTestFile(t, dir, "b.json", `[
+		{"type": "counter", "name": "test_dir_two", "help": "Two"},
+		{"type": "gauge", "name": "test_dir_one", "help": "One"},
+		{"type": "gauge", "name": "test_dir_three", "help": "Three"}
+	]`)
+	writeTestFile(t, dir, "c.json", `[{"type": "counter",`)
+	writeTestFile(t, dir, ".d.json", `nope`)
+	writeTestFile(t, dir, "e.txt", `nope`)
+
+	set, err := LoadSpecs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, spec := range set.Specs {
+		names = append(names, spec.Name)
+	}
+	if !sliceEqStr(names, []string{"test_dir_one", "test_dir_two", "test_dir_three"}) {
+		t.Errorf("Expected specs from a.json and b.json, but got %v", names)
+	}
+	if set.Specs[0].File != filepath.Join(dir, "a.json") {
+		t.Errorf("Expected test_dir_one to be loaded from a.json, but was %s", set.Specs[0].File)
+	}
+
+	if len(set.Errors) != 2 {
+		t.Fatalf("Expected 2 errors, but got %v", set.Errors)
+	}
+	if set.Errors[0].File != filepath.Join(dir, "b.json") || set.Errors[0].Name != "test_dir_one" {
+		t.Errorf("Expected conflict for test_dir_one in b.json, but got %s", set.Errors[0])
+	}
+	if failed := set.FailedFiles(); !sliceEqStr(failed, []string{filepath.Join(dir, "c.json")}) {
+		t.Errorf("Expected c.json to fail, but got %v", failed)
+	}
+
+	set, err = LoadSpecs(filepath.Join(dir, "[ab].json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Specs) != 3 || len(set.Errors) != 1 {
+		t.Errorf("Expected glob to load 3 specs with 1 error, but got %d specs and %v", len(set.Specs), set.Errors)
+	}
+
+	if _, err := LoadSpecs(filepath.Join(dir, "c.json")); err == nil {
+		t.Error("Expected broken single file to throw error, but did not")
+	}
+	if _, err := LoadSpecs(filepath.Join(dir, "*.yml")); err == nil {
+		t.Error("Expected glob without matches to throw error, but did not")
+	}
+}
+
+func TestReloadSpecsDir(t *testing.T) {
+	SetTestLogger()
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestFile(t, dir, "a.json", `[{"type": "counter", "name": "test_reload_dir_a", "help": "A"}]`)
+	writeTestFile(t, dir, "b.json", `[{"type": "counter", "name": "test_reload_dir_b", "help": "B"}]`)
+
+	registry := NewRegistry()
+	if _, err := ReloadSpecs(registry, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// a broken file keeps its metrics, and does not stop others loading
+	writeTestFile(t, dir, "a.json", `[{"type": "counter",`)
+	writeTestFile(t, dir, "b.json", `[{"type": "counter", "name": "test_reload_dir_c", "help": "C"}]`)
+	if _, err := ReloadSpecs(registry, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	names := map[string]bool{}
+	for _, name := range registry.Names() {
+		names[name] = true
+	}
+	if len(names) != 2 || !names["test_reload_dir_a"] || !names["test_reload_dir_c"] {
+		t.Errorf("Expected test_reload_dir_a and test_reload_dir_c to be registered, but got %v", names)
+	}
+}
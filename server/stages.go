@@ -0,0 +1,288 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// Stage processes each metric after it is parsed and before it is
+// handled. It returns the metrics to handle in place of m, which may be
+// m itself, a changed copy, none to drop it or several to split it.
+type Stage interface {
+	Process(m Metric) ([]Metric, error)
+}
+
+type StageFunc func(m Metric) ([]Metric, error)
+
+func (f StageFunc) Process(m Metric) ([]Metric, error) {
+	return f(m)
+}
+
+// Chain is a stage which runs metrics through each of its stages in
+// order.
+type Chain []Stage
+
+func (c Chain) Process(m Metric) ([]Metric, error) {
+	metrics := []Metric{m}
+
+	for _, stage := range c {
+		var next []Metric
+		for _, m := range metrics {
+			result, err := stage.Process(m)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, result...)
+		}
+		if len(next) == 0 {
+			return nil, nil
+		}
+		metrics = next
+	}
+
+	return metrics, nil
+}
+
+// StageFactory builds a stage from its json configuration, the object
+// given in the stages list of the config file.
+type StageFactory func(config json.RawMessage) (Stage, error)
+
+var (
+	stageTypesMu sync.RWMutex
+	stageTypes   = make(map[string]StageFactory)
+)
+
+func init() {
+	MustRegisterStageType("filter", newFilterStage)
+	MustRegisterStageType("scale", newScaleStage)
+	MustRegisterStageType("audit", newAuditStage)
+	MustRegisterStageType("copy", newCopyStage)
+}
+
+// RegisterStageType registers factory to build stages whose config has
+// type name.
+func RegisterStageType(name string, factory StageFactory) error {
+	stageTypesMu.Lock()
+	defer stageTypesMu.Unlock()
+
+	if name == "" || factory == nil {
+		return fmt.Errorf("Stage type must have a name and factory")
+	}
+	if _, ok := stageTypes[name]; ok {
+		return fmt.Errorf("Stage type %s already exists", name)
+	}
+
+	stageTypes[name] = factory
+	return nil
+}
+
+// MustRegisterStageType is like RegisterStageType but panics on error.
+func MustRegisterStageType(name string, factory StageFactory) {
+	if err := RegisterStageType(name, factory); err != nil {
+		panic(err)
+	}
+}
+
+// StageTypes returns the sorted names of the registered stage types.
+func StageTypes() []string {
+	stageTypesMu.RLock()
+	defer stageTypesMu.RUnlock()
+
+	var result []string
+	for name := range stageTypes {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// NewStage builds a stage from its json configuration using the factory
+// of its type.
+func NewStage(config json.RawMessage) (Stage, error) {
+	var base struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(config, &base); err != nil {
+		return nil, err
+	}
+
+	stageTypesMu.RLock()
+	factory, ok := stageTypes[base.Type]
+	stageTypesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown stage type '%s'", base.Type)
+	}
+
+	return factory(config)
+}
+
+// decodeStageConfig decodes the config of a built-in stage, rejecting
+// unknown fields so that misspelled options are not ignored.
+func decodeStageConfig(config json.RawMessage, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(config))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// nameMatcher matches metric names against an anchored regex, an empty
+// regex matches every name.
+type nameMatcher struct {
+	re *regexp.Regexp
+}
+
+func newNameMatcher(match string) (nameMatcher, error) {
+	if match == "" {
+		return nameMatcher{}, nil
+	}
+
+	re, err := regexp.Compile("^(?:" + match + ")$")
+	if err != nil {
+		return nameMatcher{}, fmt.Errorf("Invalid match '%s': %s", match, err)
+	}
+
+	return nameMatcher{re}, nil
+}
+
+func (n nameMatcher) Match(name string) bool {
+	return n.re == nil || n.re.MatchString(name)
+}
+
+type filterConfig struct {
+	Type   string `json:"type"`
+	Match  string `json:"match"`
+	Action string `json:"action"`
+}
+
+// newFilterStage drops metrics whose names match, or with the keep
+// action, drops metrics whose names do not match.
+func newFilterStage(config json.RawMessage) (Stage, error) {
+	var c filterConfig
+	if err := decodeStageConfig(config, &c); err != nil {
+		return nil, err
+	}
+
+	matcher, err := newNameMatcher(c.Match)
+	if err != nil {
+		return nil, err
+	}
+
+	var keep bool
+	switch c.Action {
+	case "", "drop":
+	case "keep":
+		keep = true
+	default:
+		return nil, fmt.Errorf("Unknown filter action '%s', must be keep or drop", c.Action)
+	}
+
+	return StageFunc(func(m Metric) ([]Metric, error) {
+		if matcher.Match(m.Name) != keep {
+			return nil, nil
+		}
+		return []Metric{m}, nil
+	}), nil
+}
+
+type scaleConfig struct {
+	Type   string  `json:"type"`
+	Match  string  `json:"match"`
+	Factor float64 `json:"factor"`
+}
+
+// newScaleStage multiplies the values of matching metrics by a factor,
+// such as 0.001 to convert milliseconds to seconds.
+func newScaleStage(config json.RawMessage) (Stage, error) {
+	var c scaleConfig
+	if err := decodeStageConfig(config, &c); err != nil {
+		return nil, err
+	}
+
+	matcher, err := newNameMatcher(c.Match)
+	if err != nil {
+		return nil, err
+	}
+	if c.Factor == 0 {
+		return nil, fmt.Errorf("Scale factor must be set")
+	}
+
+	return StageFunc(func(m Metric) ([]Metric, error) {
+		if !matcher.Match(m.Name) {
+			return []Metric{m}, nil
+		}
+
+		m.Value *= c.Factor
+		m.Sum *= c.Factor
+		if len(m.Values) > 0 {
+			values := make([]float64, len(m.Values))
+			for i, v := range m.Values {
+				values[i] = v * c.Factor
+			}
+			m.Values = values
+		}
+
+		return []Metric{m}, nil
+	}), nil
+}
+
+type auditConfig struct {
+	Type  string `json:"type"`
+	Match string `json:"match"`
+}
+
+// newAuditStage logs matching metrics.
+func newAuditStage(config json.RawMessage) (Stage, error) {
+	var c auditConfig
+	if err := decodeStageConfig(config, &c); err != nil {
+		return nil, err
+	}
+
+	matcher, err := newNameMatcher(c.Match)
+	if err != nil {
+		return nil, err
+	}
+
+	return StageFunc(func(m Metric) ([]Metric, error) {
+		if matcher.Match(m.Name) {
+			logger.Printf("AUDIT %+v", m)
+		}
+		return []Metric{m}, nil
+	}), nil
+}
+
+type copyConfig struct {
+	Type  string `json:"type"`
+	Match string `json:"match"`
+	To    string `json:"to"`
+}
+
+// newCopyStage also sends matching metrics to another metric, which must
+// have the same labels.
+func newCopyStage(config json.RawMessage) (Stage, error) {
+	var c copyConfig
+	if err := decodeStageConfig(config, &c); err != nil {
+		return nil, err
+	}
+
+	matcher, err := newNameMatcher(c.Match)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateMetric(c.To); err != nil {
+		return nil, err
+	}
+
+	return StageFunc(func(m Metric) ([]Metric, error) {
+		if !matcher.Match(m.Name) {
+			return []Metric{m}, nil
+		}
+
+		copied := m
+		copied.Name = c.To
+		return []Metric{m, copied}, nil
+	}), nil
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	var chain Chain
+	for _, config := range []string{
+		`{"type": "filter", "match": "debug_.*"}`,
+		`{"type": "filter", "match": "app_.*", "action": "keep"}`,
+		`{"type": "scale", "match": ".*_seconds", "factor": 0.001}`,
+		`{"type": "copy", "match": "app_requests_total", "to": "app_all_requests_total"}`,
+		`{"type": "audit", "match": "nothing"}`,
+	} {
+		stage, err := NewStage(json.RawMessage(config))
+		if err != nil {
+			t.Fatalf("NewStage(%s) => %s", config, err)
+		}
+		chain = append(chain, stage)
+	}
+
+	for _, tt := range []struct {
+		in  Metric
+		out []Metric
+	}{
+		{Metric{Name: "debug_app_total"}, nil},
+		{Metric{Name: "other_total"}, nil},
+		{Metric{Name: "app_gauge", Value: 5}, []Metric{{Name: "app_gauge", Value: 5}}},
+		{Metric{Name: "app_seconds", Value: 1500}, []Metric{{Name: "app_seconds", Value: 1.5}}},
+		{Metric{Name: "app_requests_total", Value: 2}, []Metric{{Name: "app_requests_total", Value: 2}, {Name: "app_all_requests_total", Value: 2}}},
+	} {
+		out, err := chain.Process(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) != len(tt.out) {
+			t.Errorf("Process(%+v) => %+v, want %+v", tt.in, out, tt.out)
+			continue
+		}
+		for i := range out {
+			if out[i].Name != tt.out[i].Name || out[i].Value != tt.out[i].Value {
+				t.Errorf("Process(%+v) => %+v, want %+v", tt.in, out, tt.out)
+			}
+		}
+	}
+
+	// values are copied before they are scaled
+	values := []float64{1000, 2000}
+	out, _ := chain.Process(Metric{Name: "app_seconds", Method: "observe_many", Values: values})
+	if values[0] != 1000 || out[0].Values[0] != 1 || out[0].Values[1] != 2 {
+		t.Errorf("Expected scaled copy of values %v, but got %v", values, out[0].Values)
+	}
+}
+
+func TestNewStageFail(t *testing.T) {
+	for _, config := range []string{
+		`{"type": "nope"}`,
+		`{"type": "filter", "match": "("}`,
+		`{"type": "filter", "action": "maybe"}`,
+		`{"type": "filter", "mtach": "app_.*"}`,
+		`{"type": "scale"}`,
+		`{"type": "copy", "to": "bad-name"}`,
+		`[]`,
+	} {
+		if _, err := NewStage(json.RawMessage(config)); err == nil {
+			t.Errorf("Expected NewStage(%s) to fail", config)
+		}
+	}
+
+	if err := RegisterStageType("filter", newFilterStage); err == nil {
+		t.Error("Expected registering a stage type twice to fail")
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maximum number of distinct unknown metric names which are tracked
+const unknownNamesLimit = 1000
+
+// name label of the samples of unknown metrics, it is not a valid metric
+// name so it cannot be mixed up with the samples of a metric
+const unknownSampleName = "(unknown)"
+
+var (
+	unknownNames = newNameCounter(unknownNamesLimit)
+
+	metricSamplesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pmp_metric_samples_total",
+			Help: "Total count of samples processed by tenant, metric name and status",
+		},
+		[]string{"tenant", "name", "status"},
+	)
+
+	parseDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "pmp_parse_duration_seconds",
+			Help:    "Time spent parsing each batch of metrics read from the socket",
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+		},
+	)
+
+	handleDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "pmp_handle_duration_seconds",
+			Help:    "Time spent handling each metric sample",
+			Buckets: prometheus.ExponentialBuckets(0.000001, 4, 10),
+		},
+	)
+
+	unknownMetricNames = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "pmp_unknown_metric_names",
+			Help: "Number of distinct unknown metric names seen",
+		},
+		func() float64 { return float64(unknownNames.Len()) },
+	)
+)
+
+// CountSample counts a processed sample by tenant and metric name.
+// Samples for unknown metrics are counted together, so that the
+// cardinality of the name label is bounded by the registered metrics.
+func CountSample(tenant, name string, err error) {
+	status := "ok"
+	if IsWarning(err) {
+		status = "warning"
+	} else if err != nil {
+		status = "error"
+	}
+
+	if ErrorReason(err) == ReasonUnknownMetric {
+		unknownNames.Add(name)
+		name = unknownSampleName
+	}
+
+	metricSamplesTotal.WithLabelValues(tenant, name, status).Inc()
+}
+
+// ForgetSamples removes the sample counts of a metric of tenant which is
+// no longer registered.
+func ForgetSamples(tenant, name string) {
+	metricSamplesTotal.DeleteLabelValues(tenant, name, "ok")
+	metricSamplesTotal.DeleteLabelValues(tenant, name, "warning")
+	metricSamplesTotal.DeleteLabelValues(tenant, name, "error")
+}
+
+type NameCount struct {
+	Name  string `json:"name"`
+	Count uint64 `json:"count"`
+}
+
+// nameCounter counts occurrences of up to limit distinct names.
+type nameCounter struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+	limit  int
+}
+
+func newNameCounter(limit int) *nameCounter {
+	return &nameCounter{counts: make(map[string]uint64), limit: limit}
+}
+
+func (c *nameCounter) Add(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.counts[name]; ok || len(c.counts) < c.limit {
+		c.counts[name]++
+	}
+}
+
+func (c *nameCounter) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.counts)
+}
+
+// Top returns the n most frequently seen names.
+func (c *nameCounter) Top(n int) []NameCount {
+	c.mu.Lock()
+	result := make([]NameCount, 0, len(c.counts))
+	for name, count := range c.counts {
+		result = append(result, NameCount{name, count})
+	}
+	c.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count == result[j].Count {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Count > result[j].Count
+	})
+
+	if n < len(result) {
+		result = result[:n]
+	}
+
+	return result
+}
+
+// UnknownMetricsHandler serves the most frequently seen unknown metric
+// names as json, the number of names is set with the n query parameter.
+func UnknownMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	n := 10
+	if s := r.URL.Query().Get("n"); s != "" {
+		var err error
+		n, err = strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, "n must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(unknownNames.Top(n))
+}
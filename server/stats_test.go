@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestNameCounter(t *testing.T) {
+	c := newNameCounter(3)
+	for _, name := range []string{"a", "b", "b", "c", "c", "c", "d", "a", "b"} {
+		c.Add(name)
+	}
+
+	if c.Len() != 3 {
+		t.Errorf("Expected 3 names to be tracked, but got %d", c.Len())
+	}
+
+	top := c.Top(2)
+	expected := []NameCount{{"b", 3}, {"c", 3}}
+	if len(top) != len(expected) {
+		t.Fatalf("Expected top %v, but got %v", expected, top)
+	}
+	for i := range top {
+		if top[i] != expected[i] {
+			t.Errorf("Expected top %v, but got %v", expected, top)
+		}
+	}
+}
+
+func TestCountSampleUnknown(t *testing.T) {
+	CountSample("", "test_unknown_sample", NewMetricError(ReasonUnknownMetric, "nope"))
+	CountSample("", "test_known_sample", errors.New("nope"))
+
+	req := httptest.NewRequest("GET", "/debug/unknown_metrics?n=100", nil)
+	w := httptest.NewRecorder()
+	UnknownMetricsHandler(w, req)
+
+	var top []NameCount
+	if err := json.NewDecoder(w.Body).Decode(&top); err != nil {
+		t.Fatal(err)
+	}
+
+	var found bool
+	for _, nc := range top {
+		if nc.Name == "test_known_sample" {
+			t.Errorf("Did not expect test_known_sample to be tracked as unknown")
+		}
+		if nc.Name == "test_unknown_sample" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected test_unknown_sample to be tracked as unknown, but got %v", top)
+	}
+
+	for _, labels := range collectSamples(t) {
+		if labels["name"] == "test_unknown_sample" {
+			t.Errorf("Expected samples of unknown metric to be counted as %s, but got %v", unknownSampleName, labels)
+		}
+	}
+	if metricRe.MatchString(unknownSampleName) {
+		t.Errorf("Expected %s not to be a valid metric name", unknownSampleName)
+	}
+}
+
+func TestForgetSamples(t *testing.T) {
+	CountSample("", "test_forget_sample", nil)
+	CountSample("billing", "test_forget_sample", nil)
+	ForgetSamples("", "test_forget_sample")
+
+	var tenants []string
+	for _, labels := range collectSamples(t) {
+		if labels["name"] == "test_forget_sample" {
+			tenants = append(tenants, labels["tenant"])
+		}
+	}
+
+	if !sliceEqStr(tenants, []string{"billing"}) {
+		t.Errorf("Expected only samples of tenant billing to be kept, but got %q", tenants)
+	}
+}
+
+// collectSamples returns the labels of every series of the sample counts.
+func collectSamples(t *testing.T) []map[string]string {
+	ch := make(chan prometheus.Metric, 1000)
+	metricSamplesTotal.Collect(ch)
+	close(ch)
+
+	var result []map[string]string
+	for metric := range ch {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		labels := make(map[string]string)
+		for _, l := range m.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		result = append(result, labels)
+	}
+	return result
+}
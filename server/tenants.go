@@ -0,0 +1,50 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var tenantRe = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Tenant is a set of metrics with definitions and a registry of their
+// own, exposed on Options.Path followed by the name of the tenant.
+type Tenant struct {
+	Name string `json:"name"`
+
+	// path of the file, directory or glob of files which contain the
+	// metric definitions of the tenant
+	Metrics string `json:"metrics"`
+
+	// registry the metrics of the tenant are registered in, defaults to
+	// NewTenantRegistry(false)
+	Registry Registry `json:"-"`
+}
+
+// validateTenants returns the first problem with tenants.
+func validateTenants(tenants []Tenant) error {
+	var names []string
+
+	for i, t := range tenants {
+		if !tenantRe.MatchString(t.Name) {
+			return fmt.Errorf("Tenant %d name '%s' is not valid", i+1, t.Name)
+		}
+		if sliceContainsStr(names, t.Name) {
+			return fmt.Errorf("Duplicate tenant found: %s", t.Name)
+		}
+		if t.Metrics == "" {
+			return fmt.Errorf("Tenant %s has no metrics", t.Name)
+		}
+		names = append(names, t.Name)
+	}
+
+	return nil
+}
+
+func tenantNames(tenants []Tenant) []string {
+	var names []string
+	for _, t := range tenants {
+		names = append(names, t.Name)
+	}
+	return names
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atongen/prom_multi_proc/client"
+)
+
+func TestValidateTenantsFail(t *testing.T) {
+	for _, tenants := range [][]Tenant{
+		{{}},
+		{{Name: "../billing", Metrics: "billing.json"}},
+		{{Name: "billing", Metrics: "billing.json"}, {Name: "billing", Metrics: "billing.json"}},
+		{{Name: "billing"}},
+	} {
+		if err := validateTenants(tenants); err == nil {
+			t.Errorf("validateTenants(%+v) => nil, want error", tenants)
+		}
+	}
+}
+
+func TestServerTenants(t *testing.T) {
+	SetTestLogger()
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "default.sock")
+	billingSocket := filepath.Join(dir, "billing.sock")
+	spec := `[{"type": "counter", "name": "test_tenant_jobs_total", "help": "Jobs"}]`
+	file := writeTestFile(t, dir, "metrics.json", spec)
+	billingFile := writeTestFile(t, dir, "billing.json", spec)
+	searchFile := writeTestFile(t, dir, "search.json", spec)
+
+	s := NewServer(Options{
+		Socket:    socket,
+		Metrics:   file,
+		Listeners: []Listener{{Socket: billingSocket, Tenant: "billing"}},
+		Tenants:   []Tenant{{Name: "billing", Metrics: billingFile}, {Name: "search", Metrics: searchFile}},
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Run(context.Background())
+	}()
+
+	eventually(t, "Expected metrics to be registered", func() bool {
+		return len(s.Registry().Names()) == 1 &&
+			len(s.TenantRegistry("billing").Names()) == 1 &&
+			len(s.TenantRegistry("search").Names()) == 1
+	})
+
+	// the tenant of a listener may only be sent to on its socket
+	c := client.New(client.Options{Socket: socket})
+	c.Send(client.Metric{Name: "test_tenant_jobs_total", Method: "add", Value: 7, Tenant: "billing"})
+	c.Inc("test_tenant_jobs_total")
+	c.Send(client.Metric{Name: "test_tenant_jobs_total", Method: "add", Value: 3, Tenant: "search"})
+	c.Send(client.Metric{Name: "test_tenant_jobs_total", Method: "add", Value: 5, Tenant: "nope"})
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the socket of a listener overrides the tenant sent
+	c = client.New(client.Options{Socket: billingSocket, Tenant: "search"})
+	c.Add("test_tenant_jobs_total", 2)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	scrape := func(path string) string {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Body.String()
+	}
+
+	eventually(t, "Expected metrics to be handled", func() bool {
+		return strings.Contains(scrape("/metrics"), "test_tenant_jobs_total 1") &&
+			strings.Contains(scrape("/metrics/billing"), "test_tenant_jobs_total 2") &&
+			strings.Contains(scrape("/metrics/search"), "test_tenant_jobs_total 3")
+	})
+
+	if body := scrape("/metrics/billing"); strings.Contains(body, "pmp_") {
+		t.Errorf("Expected tenant to only expose its own metrics, but got %s", body)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+
+	if names := s.TenantRegistry("billing").Names(); len(names) != 0 {
+		t.Errorf("Expected tenant metrics to be unregistered, but got %v", names)
+	}
+}
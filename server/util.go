@@ -1,4 +1,4 @@
-package main
+package server
 
 func sliceContainsStr(a []string, b string) bool {
 	for _, c := range a {
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"math"
+)
+
+// checkValues scales the values of metric by the scale of spec, then
+// checks they are finite and within its bounds. Counters cannot be
+// decreased, so their values must not be negative.
+func checkValues(spec *MetricSpec, metric *Metric) error {
+	scale := spec.Scale
+	if scale == 0 {
+		scale = 1
+	}
+
+	check := func(v float64) (float64, error) {
+		v *= scale
+		switch {
+		case math.IsNaN(v) || math.IsInf(v, 0):
+			return v, NewMetricError(ReasonNonFinite, "Metric %s value %g is not finite", metric.Name, v)
+		case spec.Type == "counter" && v < 0:
+			return v, NewMetricError(ReasonNegativeCounter, "Metric %s counter cannot decrease in value", metric.Name)
+		case spec.Min != nil && v < *spec.Min:
+			return v, NewMetricError(ReasonOutOfRange, "Metric %s value %g is less than min %g", metric.Name, v, *spec.Min)
+		case spec.Max != nil && v > *spec.Max:
+			return v, NewMetricError(ReasonOutOfRange, "Metric %s value %g is greater than max %g", metric.Name, v, *spec.Max)
+		}
+		return v, nil
+	}
+
+	var err error
+	switch handledMethod(spec.Type, metric.Method) {
+	case "add", "sub", "set", "observe":
+		metric.Value, err = check(metric.Value)
+	case "observe_many":
+		values := make([]float64, len(metric.Values))
+		for i, v := range metric.Values {
+			if values[i], err = check(v); err != nil {
+				return err
+			}
+		}
+		metric.Values = values
+	case "observe_buckets":
+		// bucket bounds are in the units of the spec already, and the sum
+		// is only bounded by min and max when there is one observation
+		metric.Sum *= scale
+		if math.IsNaN(metric.Sum) || math.IsInf(metric.Sum, 0) {
+			err = NewMetricError(ReasonNonFinite, "Metric %s sum %g is not finite", metric.Name, metric.Sum)
+		}
+	}
+
+	return err
+}
+
+// handledMethod returns the method a handler of metricType performs for
+// method. Histograms and summaries observe the value of any method they
+// don't otherwise handle, which is only logged unless strict.
+func handledMethod(metricType, method string) string {
+	switch {
+	case metricType == "histogram" && method != "observe_many" && method != "observe_buckets":
+		return "observe"
+	case metricType == "summary" && method != "observe_many":
+		return "observe"
+	}
+	return method
+}
+
+// valueErrors returns the problems with the scale and bounds of spec.
+func valueErrors(spec *MetricSpec) []error {
+	var errs []error
+
+	if spec.Scale < 0 {
+		errs = append(errs, fmt.Errorf("Metric %s scale must not be negative", spec.Name))
+	}
+	if spec.Min != nil && spec.Max != nil && *spec.Min > *spec.Max {
+		errs = append(errs, fmt.Errorf("Metric %s min %g is greater than max %g", spec.Name, *spec.Min, *spec.Max))
+	}
+
+	return errs
+}
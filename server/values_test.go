@@ -0,0 +1,85 @@
+package server
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCheckValues(t *testing.T) {
+	min, max := 0.0, 10.0
+	spec := &MetricSpec{Name: "test_values", Type: "histogram", Scale: 0.001, Min: &min, Max: &max}
+	counter := &MetricSpec{Name: "test_values_total", Type: "counter"}
+	summary := &MetricSpec{Name: "test_values_summary", Type: "summary", Scale: 2}
+
+	for _, tt := range []struct {
+		spec   *MetricSpec
+		in     Metric
+		value  float64
+		values []float64
+		reason string
+	}{
+		{spec, Metric{Method: "observe", Value: 1500}, 1.5, nil, ""},
+		{spec, Metric{Method: "observe", Value: 20000}, 0, nil, ReasonOutOfRange},
+		{spec, Metric{Method: "observe", Value: -1}, 0, nil, ReasonOutOfRange},
+		{spec, Metric{Method: "observe", Value: math.NaN()}, 0, nil, ReasonNonFinite},
+		{spec, Metric{Method: "observe_many", Values: []float64{1000, 2000}}, 0, []float64{1, 2}, ""},
+		{spec, Metric{Method: "observe_many", Values: []float64{1000, math.Inf(1)}}, 0, nil, ReasonNonFinite},
+		{counter, Metric{Method: "add", Value: 2}, 2, nil, ""},
+		{counter, Metric{Method: "add", Value: -1}, 0, nil, ReasonNegativeCounter},
+		{counter, Metric{Method: "add", Value: math.Inf(-1)}, 0, nil, ReasonNonFinite},
+		{counter, Metric{Method: "inc"}, 0, nil, ""},
+		{spec, Metric{Method: "", Value: 1500}, 1.5, nil, ""},
+		{spec, Metric{Method: "inc", Value: math.NaN()}, 0, nil, ReasonNonFinite},
+		{spec, Metric{Method: "set", Value: 20000}, 0, nil, ReasonOutOfRange},
+		{summary, Metric{Method: "observe_buckets", Value: 3}, 6, nil, ""},
+	} {
+		m := tt.in
+		err := checkValues(tt.spec, &m)
+		if err != nil || tt.reason != "" {
+			if ErrorReason(err) != tt.reason {
+				t.Errorf("checkValues(%s, %+v) => %v, want reason %q", tt.spec.Name, tt.in, err, tt.reason)
+			}
+			continue
+		}
+		if tt.values != nil {
+			for i, v := range tt.values {
+				if math.Abs(m.Values[i]-v) > 1e-9 {
+					t.Errorf("checkValues(%s, %+v) => values %v, want %v", tt.spec.Name, tt.in, m.Values, tt.values)
+					break
+				}
+			}
+		} else if math.Abs(m.Value-tt.value) > 1e-9 {
+			t.Errorf("checkValues(%s, %+v) => value %g, want %g", tt.spec.Name, tt.in, m.Value, tt.value)
+		}
+	}
+}
+
+func TestCheckValuesCopiesValues(t *testing.T) {
+	spec := &MetricSpec{Name: "test_values", Type: "histogram", Scale: 2}
+	values := []float64{1, 2}
+	m := &Metric{Method: "observe_many", Values: values}
+
+	if err := checkValues(spec, m); err != nil {
+		t.Fatal(err)
+	}
+	if values[0] != 1 || m.Values[0] != 2 {
+		t.Errorf("checkValues scaled %v in place, want a copy", values)
+	}
+}
+
+func TestValueErrors(t *testing.T) {
+	one, two := 1.0, 2.0
+	for _, tt := range []struct {
+		spec *MetricSpec
+		errs int
+	}{
+		{&MetricSpec{Scale: 0.001, Min: &one, Max: &two}, 0},
+		{&MetricSpec{Min: &one, Max: &one}, 0},
+		{&MetricSpec{Scale: -1}, 1},
+		{&MetricSpec{Min: &two, Max: &one}, 1},
+	} {
+		if errs := valueErrors(tt.spec); len(errs) != tt.errs {
+			t.Errorf("valueErrors(%+v) => %v, want %d errors", tt.spec, errs, tt.errs)
+		}
+	}
+}
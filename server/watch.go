@@ -0,0 +1,112 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// how long a spec file must be left unchanged before it is reloaded
+const watchDelay = time.Second
+
+// WatchSpecs calls reload whenever file changes, once no further changes
+// have been seen for delay. The directory containing file is watched
+// rather than the file itself, so that the file is still watched after
+// it has been atomically replaced by a rename. file may also be a
+// directory or glob, in which case changes to any matching file are
+// watched for.
+func WatchSpecs(file string, delay time.Duration, reload func()) (io.Closer, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, match, err := watchTarget(file)
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	go func() {
+		var (
+			timer   *time.Timer
+			timerCh <-chan time.Time
+			errCh   = watcher.Errors
+		)
+
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					if timer != nil {
+						timer.Stop()
+					}
+					return
+				}
+				if !match(filepath.Clean(event.Name)) || event.Op == fsnotify.Chmod {
+					continue
+				}
+				if timer == nil {
+					timer = time.NewTimer(delay)
+				} else {
+					if !timer.Stop() {
+						select {
+						case <-timer.C:
+						default:
+						}
+					}
+					timer.Reset(delay)
+				}
+				timerCh = timer.C
+			case <-timerCh:
+				timerCh = nil
+				reload()
+			case err, ok := <-errCh:
+				if !ok {
+					errCh = nil
+					continue
+				}
+				logger.Printf("ERROR (WatchSpecs): %s", err)
+			}
+		}
+	}()
+
+	return watcher, nil
+}
+
+// watchTarget returns the directory to watch for changes to the metrics
+// path, and a function which reports whether a file in it is relevant.
+func watchTarget(path string) (string, func(string) bool, error) {
+	path = filepath.Clean(path)
+
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return path, func(name string) bool {
+			return filepath.Dir(name) == path && isSpecFile(filepath.Base(name))
+		}, nil
+	}
+
+	dir := filepath.Dir(path)
+	if isGlob(dir) {
+		return "", nil, fmt.Errorf("Cannot watch %s, only the file name may be a glob", path)
+	}
+
+	if isGlob(path) {
+		return dir, func(name string) bool {
+			ok, _ := filepath.Match(path, name)
+			return ok
+		}, nil
+	}
+
+	return dir, func(name string) bool {
+		return name == path
+	}, nil
+}
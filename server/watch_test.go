@@ -0,0 +1,63 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchSpecs(t *testing.T) {
+	SetTestLogger()
+	dir, err := ioutil.TempDir("", "pmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := writeTestFile(t, dir, "metrics.json", `[]`)
+	reloadCh := make(chan bool, 10)
+
+	watcher, err := WatchSpecs(file, 50*time.Millisecond, func() {
+		reloadCh <- true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	expectReload := func(what string) {
+		select {
+		case <-reloadCh:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected %s to trigger reload, but did not", what)
+		}
+		select {
+		case <-reloadCh:
+			t.Fatalf("Expected %s to trigger a single reload, but got more", what)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+
+	// several quick writes are debounced into one reload
+	for i := 0; i < 3; i++ {
+		writeTestFile(t, dir, "metrics.json", `[]`)
+	}
+	expectReload("write")
+
+	// atomic rename-replace
+	tmp := writeTestFile(t, dir, "metrics.json.tmp", `[]`)
+	if err := os.Rename(tmp, file); err != nil {
+		t.Fatal(err)
+	}
+	expectReload("rename")
+
+	// other files in the directory are ignored
+	writeTestFile(t, dir, "other.json", `[]`)
+	select {
+	case <-reloadCh:
+		t.Fatalf("Did not expect %s to trigger reload", filepath.Join(dir, "other.json"))
+	case <-time.After(200 * time.Millisecond):
+	}
+}